@@ -1,54 +1,328 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	dl "github.com/troppes/portable-container-engine/internal/image"
 	pce "github.com/troppes/portable-container-engine/internal/runtime"
 )
 
+const usage = `Usage: pce <download|run> <image> [<command>...]
+       pce run [-d] [-it] [<options>] <image> [<command>...]
+       pce run [-d] [--name <name>] --bundle <dir>
+       pce create [<options>] <image> [<command>...]
+       pce create [--name <name>] --bundle <dir>
+       pce spec [--bundle <dir>] [<options>] <image> [<command>...]
+       pce start [-d] <container>
+       pce exec <container> <command>...
+       pce ps [-a]
+       pce logs [-f] [--since <time>] [--tail <n>] <container>
+       pce stop [-t <seconds>] <container>...
+       pce kill [-s <signal>] <container>...
+       pce rm [-f] <container>...`
+
 func main() {
 	args := os.Args
 
-	if len(args) < 3 {
-		fmt.Println("Usage: pce <download|run> <image> [<command>...]")
-		return
+	if len(args) < 2 {
+		fmt.Println(usage)
+		os.Exit(pce.ExitEngineError)
 	}
 
 	mode := args[1]
-	image := args[2]
-	command := []string{}
-	if len(args) > 3 {
-		command = args[3:]
-	}
-
-	if image == "" {
-		fmt.Println("Please provide a valid image name")
-		return
-	}
 
 	// Get the platform-appropriate container runtime
 	containerRuntime := pce.GetRuntime()
 
 	switch mode {
-	case "run":
-		if err := containerRuntime.Run(image, command); err != nil {
-			fmt.Printf("Error running container: %v\n", err)
+	case "run", "create":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		opts := runFlags(flags)
+		var detach bool
+		if mode == "run" {
+			flags.BoolVar(&detach, "d", false, "Run the container in the background")
+			flags.BoolVar(&detach, "detach", false, "Run the container in the background")
+		}
+		flags.StringVar(&opts.Bundle, "bundle", "", "Run the OCI bundle in the directory instead of an image")
+		flags.StringVar(&opts.Bundle, "b", "", "Run the OCI bundle in the directory instead of an image")
+		if err := flags.Parse(splitBoolFlags(flags, args[2:])); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		var image string
+		var command []string
+		if opts.Bundle != "" {
+			if err := checkBundleFlags(flags); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(pce.ExitEngineError)
+			}
+		} else {
+			if flags.NArg() < 1 || flags.Arg(0) == "" {
+				fmt.Println("Please provide a valid image name")
+				os.Exit(pce.ExitEngineError)
+			}
+			image, command = flags.Arg(0), flags.Args()[1:]
+		}
+
+		if mode == "create" || detach {
+			c, err := containerRuntime.Create(image, command, *opts)
+			if err != nil {
+				fmt.Printf("Error creating container: %v\n", err)
+				os.Exit(pce.ExitEngineError)
+			}
+			// Detached containers are kept after they exit, so their logs
+			// can still be read
+			if detach {
+				if err := containerRuntime.Start(c.ID, true); err != nil {
+					fmt.Printf("Error starting container: %v\n", err)
+					os.Exit(pce.ExitEngineError)
+				}
+			}
+			fmt.Println(c.ID)
 			return
 		}
 
+		err := containerRuntime.Run(image, command, *opts)
+		exitWithStatus("Error running container", err)
+
+	case "spec":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		opts := runFlags(flags)
+		flags.StringVar(&opts.Bundle, "bundle", ".", "Directory to write the OCI bundle to")
+		flags.StringVar(&opts.Bundle, "b", ".", "Directory to write the OCI bundle to")
+		if err := flags.Parse(splitBoolFlags(flags, args[2:])); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() < 1 || flags.Arg(0) == "" {
+			fmt.Println("Please provide a valid image name")
+			os.Exit(pce.ExitEngineError)
+		}
+		if err := containerRuntime.Spec(flags.Arg(0), flags.Args()[1:], *opts); err != nil {
+			fmt.Printf("Error writing bundle: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "start":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		var detach bool
+		flags.BoolVar(&detach, "d", false, "Run the container in the background")
+		flags.BoolVar(&detach, "detach", false, "Run the container in the background")
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() < 1 {
+			fmt.Println("Please provide a container")
+			os.Exit(pce.ExitEngineError)
+		}
+		err := containerRuntime.Start(flags.Arg(0), detach)
+		if detach && err == nil {
+			fmt.Println(flags.Arg(0))
+		}
+		exitWithStatus("Error starting container", err)
+
+	case "exec":
+		if len(args) < 4 {
+			fmt.Println("Please provide a container and a command")
+			os.Exit(pce.ExitEngineError)
+		}
+		err := containerRuntime.Exec(args[2], args[3:])
+		exitWithStatus("Error executing command", err)
+
+	case "logs":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		var opts pce.LogOptions
+		flags.BoolVar(&opts.Follow, "f", false, "Follow the log output")
+		flags.BoolVar(&opts.Follow, "follow", false, "Follow the log output")
+		since := flags.String("since", "", "Show logs since a timestamp (e.g. 2025-01-02T15:04:05Z) or relative time (e.g. 10m)")
+		flags.IntVar(&opts.Tail, "tail", -1, "Number of lines to show from the end of the logs, all if negative")
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() < 1 {
+			fmt.Println("Please provide a container")
+			os.Exit(pce.ExitEngineError)
+		}
+
+		var err error
+		if opts.Since, err = pce.ParseSince(*since); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+		if err := containerRuntime.Logs(flags.Arg(0), opts); err != nil {
+			fmt.Printf("Error reading logs: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "ps":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		all := flags.Bool("a", false, "Show all containers, not only running ones")
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+
+		containers, err := containerRuntime.List()
+		if err != nil {
+			fmt.Printf("Error listing containers: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tNAMES")
+		for _, c := range containers {
+			if !*all && c.State.Status != pce.StatusRunning {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%q\t%s\t%s\t%s\n",
+				c.ID[:12], c.ImageString(), truncate(strings.Join(c.Command, " "), 20), c.CreatedString(), c.StatusString(), c.Name)
+		}
+		w.Flush()
+
+	case "stop":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		var timeout *int
+		flags.Func("t", "Seconds to wait for the container to exit before killing it", func(value string) error {
+			t, err := strconv.Atoi(value)
+			if err != nil || t < 0 {
+				return fmt.Errorf("invalid timeout %q", value)
+			}
+			timeout = &t
+			return nil
+		})
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() == 0 {
+			fmt.Println("Please provide at least one container")
+			os.Exit(pce.ExitEngineError)
+		}
+
+		failed := false
+		for _, container := range flags.Args() {
+			if err := containerRuntime.Stop(container, timeout); err != nil {
+				fmt.Printf("Error stopping container: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(container)
+		}
+		if failed {
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "kill":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		signal := flags.String("s", "KILL", "Signal to send to the container")
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() == 0 {
+			fmt.Println("Please provide at least one container")
+			os.Exit(pce.ExitEngineError)
+		}
+
+		failed := false
+		for _, container := range flags.Args() {
+			if err := containerRuntime.Kill(container, *signal); err != nil {
+				fmt.Printf("Error killing container: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(container)
+		}
+		if failed {
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "rm":
+		flags := flag.NewFlagSet(mode, flag.ContinueOnError)
+		force := flags.Bool("f", false, "Kill running containers before removing them")
+		if err := flags.Parse(args[2:]); err != nil {
+			os.Exit(pce.ExitEngineError)
+		}
+		if flags.NArg() == 0 {
+			fmt.Println("Please provide at least one container")
+			os.Exit(pce.ExitEngineError)
+		}
+
+		// Like Docker the other containers are still removed if one fails
+		failed := false
+		for _, container := range flags.Args() {
+			if err := containerRuntime.Remove(container, *force); err != nil {
+				fmt.Printf("Error removing container: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Println(container)
+		}
+		if failed {
+			os.Exit(pce.ExitEngineError)
+		}
+
 	case "internalrun":
-		if len(command) == 0 {
+		if len(args) < 4 {
 			fmt.Println("Please provide a command for internal run")
-			return
+			os.Exit(pce.ExitEngineError)
 		}
-		if err := containerRuntime.CreateChildProcess(image, command); err != nil {
-			fmt.Printf("Error creating child process: %v\n", err)
-			return
+		err := containerRuntime.CreateChildProcess(args[2], args[3:])
+		exitWithStatus("Error creating child process", err)
+
+	case "internalexec":
+		if len(args) < 4 {
+			fmt.Println("Please provide a command for internal exec")
+			os.Exit(pce.ExitEngineError)
+		}
+		err := containerRuntime.ExecChildProcess(args[2], args[3:])
+		exitWithStatus("Error executing child process", err)
+
+	case "internalports":
+		if err := containerRuntime.ServePorts(); err != nil {
+			fmt.Printf("Error serving ports: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "internalunpack":
+		if len(args) < 3 {
+			fmt.Println("Please provide a directory for internal unpack")
+			os.Exit(pce.ExitEngineError)
+		}
+		if err := containerRuntime.UnpackLayer(args[2]); err != nil {
+			fmt.Printf("Error unpacking layer: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "internalcopylayers":
+		if len(args) < 3 {
+			fmt.Println("Please provide a directory for internal copy layers")
+			os.Exit(pce.ExitEngineError)
+		}
+		if err := containerRuntime.CopyLayers(args[2], args[3:]); err != nil {
+			fmt.Printf("Error copying layers: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+
+	case "internalsupervise":
+		if len(args) < 3 {
+			fmt.Println("Please provide a container for internal supervise")
+			os.Exit(pce.ExitEngineError)
+		}
+		if err := containerRuntime.Supervise(args[2]); err != nil {
+			fmt.Printf("Error supervising container: %v\n", err)
+			os.Exit(pce.ExitEngineError)
 		}
 
 	case "download":
+		if len(args) < 3 || args[2] == "" {
+			fmt.Println("Please provide a valid image name")
+			os.Exit(pce.ExitEngineError)
+		}
+		image := args[2]
+		command := args[3:]
+
 		extract := false
 		if len(command) > 0 && (command[0] == "--extract" || command[0] == "-x") {
 			extract = true
@@ -64,27 +338,234 @@ func main() {
 			err := os.Mkdir(dlDir, 0755)
 			if err != nil {
 				fmt.Printf("Error creating download directory: %v\n", err)
-				return
+				os.Exit(pce.ExitEngineError)
 			}
 		} else {
 			// Some other error occurred while checking
 			fmt.Printf("Error checking directory: %v\n", err)
-			return
+			os.Exit(pce.ExitEngineError)
 		}
 
 		dlPath, _, err := dl.RetrieveImage(image, extract, dlDir)
 		if err != nil {
 			fmt.Printf("Error downloading image: %v\n", err)
+			os.Exit(pce.ExitEngineError)
+		}
+		if extract {
+			fmt.Printf("Image extracted to %v\n", dlPath)
 		} else {
-			if extract {
-				fmt.Printf("Image extracted to %v\n", dlPath)
-			} else {
-				fmt.Printf("Image downloaded to %v\n", dlPath)
-			}
+			fmt.Printf("Image downloaded to %v\n", dlPath)
 		}
 
 	default:
 		fmt.Printf("Unknown command: %v\n", mode)
-		fmt.Println("Usage: pce <download|run|internalrun> <image> [<command|--extract>...]")
+		fmt.Println(usage)
+		os.Exit(pce.ExitEngineError)
+	}
+}
+
+// runFlags registers the container options shared by run and create.
+func runFlags(flags *flag.FlagSet) *pce.RunOptions {
+	opts := &pce.RunOptions{}
+	flags.StringVar(&opts.Name, "name", "", "Assign a name to the container")
+	flags.StringVar(&opts.Network, "network", pce.NetworkHost, "Network mode of the container: host or private")
+	flags.StringVar(&opts.IPC, "ipc", pce.NamespacePrivate, "IPC namespace of the container: private or host")
+	flags.StringVar(&opts.CgroupNS, "cgroupns", pce.NamespacePrivate, "Cgroup namespace of the container: private or host")
+	flags.StringVar(&opts.TimeNS, "timens", pce.NamespaceHost, "Time namespace of the container: host or private, where the boot time starts at zero")
+	flags.Var((*portsFlag)(&opts.Ports), "p", "Publish a container port on the host as [ip:]hostPort:containerPort[/udp]")
+	flags.Var((*portsFlag)(&opts.Ports), "publish", "Publish a container port on the host as [ip:]hostPort:containerPort[/udp]")
+	flags.Var((*envFlag)(&opts.Env), "e", "Set an environment variable as KEY=VALUE, a bare KEY takes the value of the host")
+	flags.Var((*envFlag)(&opts.Env), "env", "Set an environment variable as KEY=VALUE, a bare KEY takes the value of the host")
+	flags.Func("env-file", "Read environment variables from a file with one KEY=VALUE per line", func(value string) error {
+		env, err := pce.ParseEnvFile(value)
+		opts.Env = append(opts.Env, env...)
+		return err
+	})
+	volume := func(value string) error {
+		m, err := pce.ParseVolume(value)
+		if err != nil {
+			return err
+		}
+		opts.Mounts = append(opts.Mounts, m)
+		return nil
+	}
+	flags.Func("v", "Mount a host path or named volume as source:destination[:ro]", volume)
+	flags.Func("volume", "Mount a host path or named volume as source:destination[:ro]", volume)
+	flags.Func("tmpfs", "Mount a tmpfs as destination[:options], e.g. /run:size=64m", func(value string) error {
+		m, err := pce.ParseTmpfs(value)
+		if err != nil {
+			return err
+		}
+		opts.Mounts = append(opts.Mounts, m)
+		return nil
+	})
+	flags.Func("memory", "Memory limit, e.g. 512m or 2g", func(value string) (err error) {
+		opts.Resources.Memory, err = pce.ParseBytes(value)
+		return err
+	})
+	flags.Float64Var(&opts.Resources.CPUs, "cpus", 0, "Number of CPUs the container may use, e.g. 1.5")
+	flags.Int64Var(&opts.Resources.PidsLimit, "pids-limit", 0, "Maximum number of processes in the container")
+	flags.Int64Var(&opts.Resources.CPUShares, "cpu-shares", 0, "Relative CPU weight compared to other containers (default 1024)")
+	flags.StringVar(&opts.StopSignal, "stop-signal", "", "Signal to stop the container with (default the image's stop signal or SIGTERM)")
+	flags.Func("stop-timeout", "Seconds a stopped container has to exit before it is killed (default 5)", func(value string) error {
+		t, err := strconv.Atoi(value)
+		if err != nil || t < 0 {
+			return fmt.Errorf("invalid timeout %q", value)
+		}
+		opts.StopTimeout = &t
+		return nil
+	})
+	flags.Func("cap-add", "Add a Linux capability like NET_ADMIN, or ALL", func(value string) error {
+		opts.CapAdd = append(opts.CapAdd, value)
+		return nil
+	})
+	flags.Func("cap-drop", "Drop a Linux capability like NET_RAW, or ALL", func(value string) error {
+		opts.CapDrop = append(opts.CapDrop, value)
+		return nil
+	})
+	flags.BoolFunc("no-new-privileges", "Keep processes from gaining privileges through setuid binaries, disable with =false (default true)", func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		opts.NewPrivileges = !enabled
+		return err
+	})
+	flags.Func("security-opt", "Security option: seccomp=profile.json, seccomp=unconfined or no-new-privileges[=false]", func(value string) error {
+		return pce.ParseSecurityOpt(value, opts)
+	})
+	flags.BoolVar(&opts.TTY, "t", false, "Allocate a pseudo-terminal")
+	flags.BoolVar(&opts.TTY, "tty", false, "Allocate a pseudo-terminal")
+	// Standard input is always attached, -i is accepted for compatibility
+	// with Docker
+	flags.Bool("i", false, "Keep standard input attached (the default)")
+	flags.Bool("interactive", false, "Keep standard input attached (the default)")
+	flags.BoolVar(&opts.Init, "init", false, "Run an init inside the container that forwards signals and reaps processes")
+	flags.BoolVar(&opts.NoPivot, "no-pivot", false, "Enter the root filesystem with chroot instead of pivot_root")
+	flags.BoolVar(&opts.ReadOnly, "read-only", false, "Mount the root filesystem of the container read-only")
+	return opts
+}
+
+// portsFlag collects repeated -p flags.
+type portsFlag []pce.PortMapping
+
+func (f *portsFlag) String() string {
+	var ports []string
+	for _, m := range *f {
+		ports = append(ports, m.String())
+	}
+	return strings.Join(ports, ",")
+}
+
+func (f *portsFlag) Set(value string) error {
+	m, err := pce.ParsePortMapping(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// envFlag collects repeated -e flags.
+type envFlag []string
+
+func (f *envFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *envFlag) Set(value string) error {
+	env, err := pce.ParseEnv(value)
+	*f = append(*f, env...)
+	return err
+}
+
+// exitWithStatus exits with the exit code for err of a command run in a
+// container. Failures of pce are printed with prefix, a command that exited
+// unsuccessfully on its own is not.
+func exitWithStatus(prefix string, err error) {
+	var exitErr *pce.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || exitErr.Err != nil) {
+		fmt.Printf("%s: %v\n", prefix, err)
+	}
+	os.Exit(pce.ExitCode(err))
+}
+
+// bundleFlags are the flags of run and create that apply to bundles, whose
+// config.json holds everything else.
+var bundleFlags = map[string]bool{
+	"bundle": true, "b": true,
+	"name": true,
+	"d":    true, "detach": true,
+	"i": true, "interactive": true,
+}
+
+// checkBundleFlags rejects images, commands and flags given together with
+// a bundle.
+func checkBundleFlags(flags *flag.FlagSet) error {
+	if flags.NArg() > 0 {
+		return fmt.Errorf("a bundle cannot be combined with an image or command")
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err == nil && !bundleFlags[f.Name] {
+			name := "--" + f.Name
+			if len(f.Name) == 1 {
+				name = "-" + f.Name
+			}
+			err = fmt.Errorf("%s cannot be combined with a bundle, set it in its config.json instead", name)
+		}
+	})
+	return err
+}
+
+// splitBoolFlags splits combined boolean flags like -it into -i -t, which
+// the flag package does not support. Arguments after the image are kept.
+func splitBoolFlags(flags *flag.FlagSet, args []string) []string {
+	isBool := func(name string) bool {
+		f := flags.Lookup(name)
+		if f == nil {
+			return false
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		return ok && b.IsBoolFlag()
+	}
+
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			return append(out, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			out = append(out, arg)
+			continue
+		}
+		if flags.Lookup(name) != nil {
+			out = append(out, arg)
+			// The value of a flag that is not boolean follows it
+			if !isBool(name) && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+			continue
+		}
+
+		split := !strings.HasPrefix(arg, "--")
+		for _, c := range name {
+			split = split && isBool(string(c))
+		}
+		if !split {
+			out = append(out, arg)
+			continue
+		}
+		for _, c := range name {
+			out = append(out, "-"+string(c))
+		}
+	}
+	return out
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
 	}
+	return s[:length-3] + "..."
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -169,3 +170,33 @@ func runInContainer(container testcontainers.Container, ctx context.Context, com
 
 	return string(output), nil
 }
+
+func TestSplitBoolFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"combined", []string{"-it", "alpine", "sh"}, []string{"-i", "-t", "alpine", "sh"}},
+		{"three", []string{"-dit", "alpine"}, []string{"-d", "-i", "-t", "alpine"}},
+		{"single", []string{"-t", "alpine"}, []string{"-t", "alpine"}},
+		{"long", []string{"--tty", "alpine"}, []string{"--tty", "alpine"}},
+		{"value", []string{"-e", "-it", "alpine"}, []string{"-e", "-it", "alpine"}},
+		{"value with equals", []string{"--name=web", "-it", "alpine"}, []string{"--name=web", "-i", "-t", "alpine"}},
+		{"command", []string{"alpine", "ls", "-la"}, []string{"alpine", "ls", "-la"}},
+		{"unknown", []string{"-ix", "alpine"}, []string{"-ix", "alpine"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("run", flag.ContinueOnError)
+			runFlags(flags)
+			flags.Bool("d", false, "")
+
+			got := splitBoolFlags(flags, tt.args)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("splitBoolFlags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ go 1.25
 require (
 	github.com/google/go-containerregistry v0.20.6
 	github.com/testcontainers/testcontainers-go v0.38.0
+	golang.org/x/sys v0.35.0
+	golang.org/x/term v0.31.0
 )
 
 require (
@@ -68,7 +70,6 @@ require (
 	go.opentelemetry.io/proto/otlp v1.7.1 // indirect
 	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
-	golang.org/x/sys v0.35.0 // indirect
 	google.golang.org/protobuf v1.36.8 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
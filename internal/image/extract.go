@@ -2,13 +2,37 @@ package image
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// xattrPAXPrefix marks extended attributes in the PAX records of a tar header.
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// modeBits are the parts of a file mode restored on extracted entries.
+const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// ExtractImage applies a layer tarball onto dest, processing OCI whiteouts
+// against whatever earlier layers left there. Ownership is only restored when
+// running as root.
 func ExtractImage(r io.Reader, dest string) error {
+	return extract(r, dest, true, nil)
+}
+
+// extractLayer unpacks a single layer tarball into dest, keeping whiteout
+// markers as they are so the layer can be stacked later. File ownership is
+// translated through ids.
+func extractLayer(r io.Reader, dest string, ids *IDMappings) error {
+	return extract(r, dest, false, ids)
+}
+
+func extract(r io.Reader, dest string, applyWhiteouts bool, ids *IDMappings) error {
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return fmt.Errorf("ExtractTar: MkdirAll() failed: %s", err.Error())
 	}
@@ -21,6 +45,14 @@ func ExtractImage(r io.Reader, dest string) error {
 
 	tarReader := tar.NewReader(r)
 
+	// Paths written by this layer, these are kept by opaque whiteouts
+	extracted := map[string]bool{}
+
+	// Writing into a directory changes its mtime and read-only directories
+	// are kept writable while extracting, so directory modes and timestamps
+	// are restored after everything else
+	dirs := map[string]dirAttrs{}
+
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -32,21 +64,44 @@ func ExtractImage(r io.Reader, dest string) error {
 
 		target := filepath.Clean(header.Name)
 
+		// Earlier layers may have left a read-only directory to write into
+		makeDirWritable(root, filepath.Dir(target), dirs)
+
+		if applyWhiteouts && isWhiteout(target) {
+			if err := applyWhiteout(root, target, extracted); err != nil {
+				fmt.Printf("Warning: Failed to apply whiteout %s: %s\n", target, err.Error())
+			}
+			continue
+		}
+
+		// Layers need not list the parents of their entries
+		if dir := filepath.Dir(target); dir != "." {
+			if err := root.MkdirAll(dir, 0755); err != nil {
+				fmt.Printf("Warning: Failed to create parent directory for %s: %s\n", target, err.Error())
+				continue
+			}
+		}
+		markExtracted(extracted, target)
+
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := root.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			if err := root.Mkdir(target, dirMode(header.FileInfo().Mode())); err != nil && !os.IsExist(err) {
 				fmt.Printf("Warning: Failed to create directory %s: %s\n", target, err.Error())
 				continue
 			}
+			applyMetadata(root, target, header, ids)
+			dirs[target] = dirAttrs{mode: header.FileInfo().Mode() & modeBits, mtime: header.ModTime}
+
 		case tar.TypeReg:
-			if dir := filepath.Dir(target); dir != "." {
-				if err := root.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
-					fmt.Printf("Warning: Failed to create parent directory for %s: %s\n", target, err.Error())
+			// Replace whatever is there, it may well be read-only
+			if info, err := root.Lstat(target); err == nil && !info.IsDir() {
+				if err := root.Remove(target); err != nil {
+					fmt.Printf("Warning: Failed to remove existing file %s: %s\n", target, err.Error())
 					continue
 				}
 			}
 
-			outFile, err := root.Create(target)
+			outFile, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 			if err != nil {
 				fmt.Printf("Warning: Failed to create file %s: %s\n", target, err.Error())
 				continue
@@ -58,12 +113,18 @@ func ExtractImage(r io.Reader, dest string) error {
 				continue
 			}
 
-			err = outFile.Chmod(0755)
-			if err != nil {
-				fmt.Printf("Warning: Failed to set permissions on %s: %s\n", target, err.Error())
-			}
 			outFile.Close()
 
+			applyMetadata(root, target, header, ids)
+
+			// Changing the owner drops file capabilities, so extended
+			// attributes come last
+			if xattrs := headerXattrs(header); len(xattrs) > 0 {
+				if err := applyXattrs(root, target, xattrs); err != nil {
+					fmt.Printf("Warning: Failed to set extended attributes on %s: %s\n", target, err.Error())
+				}
+			}
+
 		case tar.TypeSymlink:
 			// Remove existing file/symlink if it exists
 			if _, err := root.Lstat(header.Name); err == nil {
@@ -77,6 +138,7 @@ func ExtractImage(r io.Reader, dest string) error {
 				fmt.Printf("Warning: Failed to create symlink %s -> %s: %s\n", header.Name, header.Linkname, err.Error())
 				continue
 			}
+			applyMetadata(root, target, header, ids)
 
 		case tar.TypeLink:
 			if err := root.Link(header.Linkname, target); err != nil {
@@ -94,5 +156,133 @@ func ExtractImage(r io.Reader, dest string) error {
 		}
 	}
 
+	restoreDirs(root, dirs)
+
 	return nil
 }
+
+// markExtracted records path as written by the layer, along with its parent
+// directories, which the layer contains even without entries of their own.
+func markExtracted(extracted map[string]bool, path string) {
+	for ; path != "." && !extracted[path]; path = filepath.Dir(path) {
+		extracted[path] = true
+	}
+}
+
+// applyMetadata restores ownership, mode and modification time of an extracted
+// entry. The mode is set after the owner, as chown clears setuid bits.
+func applyMetadata(root *os.Root, target string, header *tar.Header, ids *IDMappings) {
+	if uid, gid, ok := hostIDs(ids, header.Uid, header.Gid); ok {
+		// Without privileges only the own IDs can be assigned, everything
+		// else stays owned by the extracting user
+		if err := root.Lchown(target, uid, gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+			fmt.Printf("Warning: Failed to set owner of %s: %s\n", target, err.Error())
+		}
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		return
+	}
+
+	mode := header.FileInfo().Mode()
+	if mode.IsDir() {
+		mode = dirMode(mode)
+	}
+	if err := root.Chmod(target, mode&modeBits); err != nil {
+		fmt.Printf("Warning: Failed to set permissions on %s: %s\n", target, err.Error())
+	}
+
+	if header.Typeflag != tar.TypeDir {
+		if err := root.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			fmt.Printf("Warning: Failed to set timestamps on %s: %s\n", target, err.Error())
+		}
+	}
+}
+
+// hostIDs returns the host owner for an entry owned by uid and gid in the
+// layer. Without mappings IDs are used as they are, which only works as root.
+func hostIDs(ids *IDMappings, uid, gid int) (int, int, bool) {
+	if ids == nil {
+		return uid, gid, os.Geteuid() == 0
+	}
+	return ids.HostIDs(uid, gid)
+}
+
+// dirMode is the mode a directory has while its children are written. An
+// unprivileged user could not fill read-only directories, so they are kept
+// accessible until restoreDirs sets the mode of the image.
+func dirMode(mode fs.FileMode) fs.FileMode {
+	mode &= modeBits
+	if os.Geteuid() != 0 {
+		mode |= 0700
+	}
+	return mode
+}
+
+// dirAttrs are the mode and modification time a directory gets once
+// everything below it is written.
+type dirAttrs struct {
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+// makeDirWritable makes an existing directory that is not in dirs accessible
+// to the extracting user and records its attributes to restore them later.
+func makeDirWritable(root *os.Root, dir string, dirs map[string]dirAttrs) {
+	if _, ok := dirs[dir]; ok || dir == "." || os.Geteuid() == 0 {
+		return
+	}
+	info, err := root.Lstat(dir)
+	if err != nil || !info.IsDir() || info.Mode().Perm()&0700 == 0700 {
+		return
+	}
+	if err := root.Chmod(dir, dirMode(info.Mode())); err == nil {
+		dirs[dir] = dirAttrs{mode: info.Mode() & modeBits, mtime: info.ModTime()}
+	}
+}
+
+// restoreDirs sets the modes and timestamps of the directories.
+func restoreDirs(root *os.Root, dirs map[string]dirAttrs) {
+	for dir, attrs := range dirs {
+		// Directories removed by a later whiteout are gone by now
+		if err := root.Chmod(dir, attrs.mode); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: Failed to set permissions on %s: %s\n", dir, err.Error())
+		}
+		if err := root.Chtimes(dir, attrs.mtime, attrs.mtime); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: Failed to set timestamps on %s: %s\n", dir, err.Error())
+		}
+	}
+}
+
+// RemoveAll removes path like os.RemoveAll. Directories keep the modes of the
+// image, so they are made writable first, an unprivileged user could not
+// empty read-only ones otherwise.
+func RemoveAll(path string) error {
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(p, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
+
+func applyXattrs(root *os.Root, target string, xattrs map[string]string) error {
+	f, err := root.Open(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return setXattrs(f, xattrs)
+}
+
+func headerXattrs(header *tar.Header) map[string]string {
+	xattrs := map[string]string{}
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, xattrPAXPrefix); ok {
+			xattrs[name] = value
+		}
+	}
+	return xattrs
+}
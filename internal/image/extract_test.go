@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestExtractImage(t *testing.T) {
@@ -295,6 +296,10 @@ type tarFile struct {
 	mode     int64
 	content  []byte
 	linkname string
+	uid      int
+	gid      int
+	modTime  time.Time
+	xattrs   map[string]string
 }
 
 func writeTarEntry(tw *tar.Writer, tf tarFile) error {
@@ -304,6 +309,15 @@ func writeTarEntry(tw *tar.Writer, tf tarFile) error {
 		Size:     int64(len(tf.content)),
 		Typeflag: tf.typeflag,
 		Linkname: tf.linkname,
+		Uid:      tf.uid,
+		Gid:      tf.gid,
+		ModTime:  tf.modTime,
+	}
+	for name, value := range tf.xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[xattrPAXPrefix+name] = value
 	}
 
 	if err := tw.WriteHeader(header); err != nil {
@@ -318,3 +332,267 @@ func writeTarEntry(tw *tar.Writer, tf tarFile) error {
 
 	return nil
 }
+
+func TestExtractImageWhiteouts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-whiteout-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lower := []tarFile{
+		{name: "etc", typeflag: tar.TypeDir, mode: 0755},
+		{name: "etc/keep.txt", typeflag: tar.TypeReg, content: []byte("keep"), mode: 0644},
+		{name: "etc/removed.txt", typeflag: tar.TypeReg, content: []byte("removed"), mode: 0644},
+		{name: "cache", typeflag: tar.TypeDir, mode: 0755},
+		{name: "cache/old.txt", typeflag: tar.TypeReg, content: []byte("old"), mode: 0644},
+		{name: "cache/sub", typeflag: tar.TypeDir, mode: 0755},
+		{name: "cache/sub/old.txt", typeflag: tar.TypeReg, content: []byte("old"), mode: 0644},
+	}
+	upper := []tarFile{
+		{name: "etc/.wh.removed.txt", typeflag: tar.TypeReg, mode: 0644},
+		{name: "cache", typeflag: tar.TypeDir, mode: 0755},
+		// Entries of the same layer survive the opaque marker regardless of order
+		{name: "cache/new.txt", typeflag: tar.TypeReg, content: []byte("new"), mode: 0644},
+		{name: "cache/.wh..wh..opq", typeflag: tar.TypeReg, mode: 0644},
+	}
+
+	dest := filepath.Join(tmpDir, "rootfs")
+	for _, layer := range [][]tarFile{lower, upper} {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		for _, tf := range layer {
+			if err := writeTarEntry(tarWriter, tf); err != nil {
+				t.Fatalf("failed to write tar entry: %v", err)
+			}
+		}
+		tarWriter.Close()
+
+		if err := ExtractImage(&buf, dest); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	checkWhiteouts(t, dest)
+}
+
+func TestExtractImageOpaqueImplicitParents(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "rootfs")
+
+	lower := []tarFile{
+		{name: "cache", typeflag: tar.TypeDir, mode: 0755},
+		{name: "cache/sub", typeflag: tar.TypeDir, mode: 0755},
+		{name: "cache/sub/old.txt", typeflag: tar.TypeReg, content: []byte("old"), mode: 0644},
+	}
+	// The layer has no entries for the directories its files are in
+	upper := []tarFile{
+		{name: "cache/sub/new.txt", typeflag: tar.TypeReg, content: []byte("new"), mode: 0644},
+		{name: "cache/deep/er/new.txt", typeflag: tar.TypeReg, content: []byte("new"), mode: 0644},
+		{name: "cache/.wh..wh..opq", typeflag: tar.TypeReg, mode: 0644},
+	}
+	for _, layer := range [][]tarFile{lower, upper} {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		for _, tf := range layer {
+			if err := writeTarEntry(tarWriter, tf); err != nil {
+				t.Fatalf("failed to write tar entry: %v", err)
+			}
+		}
+		tarWriter.Close()
+
+		if err := ExtractImage(&buf, dest); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, path := range []string{"cache/sub/new.txt", "cache/deep/er/new.txt"} {
+		if _, err := os.Stat(filepath.Join(dest, path)); err != nil {
+			t.Errorf("expected %s to exist: %v", path, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "cache/sub/old.txt")); !os.IsNotExist(err) {
+		t.Error("expected cache/sub/old.txt to be removed")
+	}
+}
+
+// checkWhiteouts verifies the result of applying the layers of
+// TestExtractImageWhiteouts
+func checkWhiteouts(t *testing.T, dir string) {
+	t.Helper()
+
+	for _, path := range []string{"etc/keep.txt", "cache/new.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("expected %s to exist: %v", path, err)
+		}
+	}
+
+	for _, path := range []string{"etc/removed.txt", "cache/old.txt", "cache/sub", "etc/.wh.removed.txt", "cache/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", path)
+		}
+	}
+}
+
+func TestExtractImageMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-metadata-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer RemoveAll(tmpDir)
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []tarFile{
+		{name: "bin", typeflag: tar.TypeDir, mode: 0755, modTime: modTime},
+		{name: "bin/ping", typeflag: tar.TypeReg, mode: 0755, content: []byte("ping"), modTime: modTime,
+			xattrs: map[string]string{"user.pce.test": "value"}},
+		{name: "bin/sudo", typeflag: tar.TypeReg, mode: 04755, content: []byte("sudo"), modTime: modTime},
+		{name: "secret", typeflag: tar.TypeReg, mode: 0600, content: []byte("secret"), modTime: modTime},
+		{name: "nginx", typeflag: tar.TypeReg, mode: 0644, content: []byte("nginx"), uid: 101, gid: 101, modTime: modTime},
+		{name: "usr", typeflag: tar.TypeDir, mode: 0555, modTime: modTime},
+		{name: "usr/lib", typeflag: tar.TypeDir, mode: 0500, modTime: modTime},
+		{name: "usr/lib/libc.so", typeflag: tar.TypeReg, mode: 0644, content: []byte("libc"), modTime: modTime},
+	}
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, tf := range files {
+		if err := writeTarEntry(tarWriter, tf); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	tarWriter.Close()
+
+	ids := &IDMappings{
+		UIDs: []IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		GIDs: []IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+	}
+	if err := extractLayer(&buf, tmpDir, ids); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Modes are preserved", func(t *testing.T) {
+		for path, want := range map[string]os.FileMode{
+			"bin/ping": 0755,
+			"bin/sudo": 0755 | os.ModeSetuid,
+			"secret":   0600,
+		} {
+			info, err := os.Stat(filepath.Join(tmpDir, path))
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", path, err)
+			}
+			if info.Mode() != want {
+				t.Errorf("mode of %s is %v, want %v", path, info.Mode(), want)
+			}
+		}
+	})
+
+	t.Run("Directory modes are preserved", func(t *testing.T) {
+		for path, want := range map[string]os.FileMode{"usr": 0555, "usr/lib": 0500} {
+			info, err := os.Stat(filepath.Join(tmpDir, path))
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", path, err)
+			}
+			if info.Mode() != want|os.ModeDir {
+				t.Errorf("mode of %s is %v, want %v", path, info.Mode(), want|os.ModeDir)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "usr/lib/libc.so")); err != nil {
+			t.Errorf("file in read-only directory missing: %v", err)
+		}
+	})
+
+	t.Run("Timestamps are preserved", func(t *testing.T) {
+		for _, path := range []string{"bin", "bin/ping", "secret", "usr", "usr/lib"} {
+			info, err := os.Stat(filepath.Join(tmpDir, path))
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", path, err)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("mtime of %s is %v, want %v", path, info.ModTime(), modTime)
+			}
+		}
+	})
+
+	t.Run("Ownership is mapped", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("assigning foreign owners requires root")
+		}
+
+		for path, want := range map[string]int{"bin/ping": os.Getuid(), "nginx": 100100} {
+			info, err := os.Stat(filepath.Join(tmpDir, path))
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", path, err)
+			}
+			uid, gid, _ := fileOwner(info)
+			if uid != want || gid != want {
+				t.Errorf("owner of %s is %d:%d, want %d:%d", path, uid, gid, want, want)
+			}
+		}
+	})
+
+	t.Run("Extended attributes are preserved", func(t *testing.T) {
+		xattrs, err := readXattrs(filepath.Join(tmpDir, "bin/ping"))
+		if err != nil {
+			t.Skipf("extended attributes not supported: %v", err)
+		}
+		if xattrs["user.pce.test"] != "value" {
+			t.Errorf("xattr user.pce.test is %q, want %q", xattrs["user.pce.test"], "value")
+		}
+	})
+
+	t.Run("Metadata survives stacking layers", func(t *testing.T) {
+		rootfs := filepath.Join(t.TempDir(), "rootfs")
+		defer RemoveAll(rootfs)
+		if err := CopyLayers([]string{tmpDir}, rootfs); err != nil {
+			t.Fatalf("failed to copy layers: %v", err)
+		}
+
+		info, err := os.Stat(filepath.Join(rootfs, "bin/sudo"))
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+		if info.Mode() != 0755|os.ModeSetuid {
+			t.Errorf("mode is %v, want %v", info.Mode(), 0755|os.ModeSetuid)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("mtime is %v, want %v", info.ModTime(), modTime)
+		}
+
+		info, err = os.Stat(filepath.Join(rootfs, "usr/lib"))
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+		if info.Mode() != 0500|os.ModeDir {
+			t.Errorf("mode is %v, want %v", info.Mode(), 0500|os.ModeDir)
+		}
+	})
+
+	t.Run("Later layers write into read-only directories", func(t *testing.T) {
+		dest := t.TempDir()
+		defer RemoveAll(dest)
+		for _, files := range [][]tarFile{
+			{{name: "usr", typeflag: tar.TypeDir, mode: 0555, modTime: modTime}},
+			{{name: "usr/new.txt", typeflag: tar.TypeReg, mode: 0644, content: []byte("new"), modTime: modTime}},
+		} {
+			r, err := layerFromFiles(t, files).Uncompressed()
+			if err != nil {
+				t.Fatalf("failed to read layer: %v", err)
+			}
+			if err := ExtractImage(r, dest); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r.Close()
+		}
+
+		if _, err := os.Stat(filepath.Join(dest, "usr/new.txt")); err != nil {
+			t.Errorf("file of the later layer missing: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(dest, "usr"))
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+		if info.Mode() != 0555|os.ModeDir {
+			t.Errorf("mode is %v, want %v", info.Mode(), 0555|os.ModeDir)
+		}
+	})
+}
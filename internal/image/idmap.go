@@ -0,0 +1,154 @@
+package image
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// maxIDs is the number of IDs root maps onto themselves, all but the
+// invalid ID 4294967295.
+const maxIDs = 4294967295
+
+// IDMap maps Size IDs starting at ContainerID inside a user namespace onto the
+// host IDs starting at HostID, like a line of /proc/<pid>/uid_map.
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// IDMappings describes the user namespace a container runs in. Ownership
+// recorded in layer tarballs is translated through it during extraction, so
+// files end up owned by the host IDs the container sees as the original ones.
+type IDMappings struct {
+	UIDs []IDMap
+	GIDs []IDMap
+}
+
+// SingleIDMappings maps only the container's root user and group onto the
+// calling user.
+func SingleIDMappings() *IDMappings {
+	return &IDMappings{
+		UIDs: []IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GIDs: []IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+}
+
+// HostIDMappings maps all IDs of the container onto the same IDs of the host,
+// which only root may do.
+func HostIDMappings() *IDMappings {
+	return &IDMappings{
+		UIDs: []IDMap{{ContainerID: 0, HostID: 0, Size: maxIDs}},
+		GIDs: []IDMap{{ContainerID: 0, HostID: 0, Size: maxIDs}},
+	}
+}
+
+// SubIDMappings maps the container's root user and group onto the calling
+// user and the other IDs onto the subordinate IDs the user is allowed to use
+// according to /etc/subuid and /etc/subgid.
+func SubIDMappings() (*IDMappings, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := SingleIDMappings()
+	uids, err := readSubIDs("/etc/subuid", u.Username, os.Getuid())
+	if err != nil {
+		return nil, err
+	}
+	gids, err := readSubIDs("/etc/subgid", u.Username, os.Getuid())
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 || len(gids) == 0 {
+		return nil, fmt.Errorf("no subordinate IDs configured for %s in /etc/subuid and /etc/subgid", u.Username)
+	}
+	ids.UIDs = append(ids.UIDs, uids...)
+	ids.GIDs = append(ids.GIDs, gids...)
+	return ids, nil
+}
+
+func readSubIDs(path, name string, id int) ([]IDMap, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSubIDs(f, name, id)
+}
+
+// parseSubIDs returns the ranges of a subuid or subgid file that belong to
+// the user given by name or id, mapped after the container's root.
+func parseSubIDs(r io.Reader, name string, id int) ([]IDMap, error) {
+	var maps []IDMap
+	next := 1
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != strconv.Itoa(id)) {
+			continue
+		}
+		start, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || start < 0 || count <= 0 {
+			continue
+		}
+		maps = append(maps, IDMap{ContainerID: next, HostID: start, Size: count})
+		next += count
+	}
+	return maps, scanner.Err()
+}
+
+// HostIDs translates a container uid and gid into host IDs. ok is false if
+// either of them is not mapped.
+func (m *IDMappings) HostIDs(uid, gid int) (hostUID, hostGID int, ok bool) {
+	hostUID, uidOK := toHost(m.UIDs, uid)
+	hostGID, gidOK := toHost(m.GIDs, gid)
+	return hostUID, hostGID, uidOK && gidOK
+}
+
+func toHost(maps []IDMap, id int) (int, bool) {
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return -1, false
+}
+
+// key identifies the mappings in the layer cache, which keeps a layer
+// unpacked for every mapping its ownership was translated through. Without
+// mappings IDs are used as they are by root and not at all by other users,
+// like with HostIDMappings and SingleIDMappings.
+func (m *IDMappings) key() string {
+	if m == nil {
+		m = SingleIDMappings()
+		if os.Geteuid() == 0 {
+			m = HostIDMappings()
+		}
+	}
+
+	h := sha256.New()
+	for _, maps := range [][]IDMap{m.UIDs, m.GIDs} {
+		for _, id := range maps {
+			fmt.Fprintf(h, "%d %d %d\n", id.ContainerID, id.HostID, id.Size)
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,85 @@
+package image
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIDMappings(t *testing.T) {
+	ids := &IDMappings{
+		UIDs: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		GIDs: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+	}
+
+	tests := []struct {
+		name     string
+		uid, gid int
+		wantUID  int
+		wantGID  int
+		wantOK   bool
+	}{
+		{name: "root", uid: 0, gid: 0, wantUID: 1000, wantGID: 1000, wantOK: true},
+		{name: "uid in range", uid: 101, gid: 0, wantUID: 100100, wantGID: 1000, wantOK: true},
+		{name: "gid not mapped", uid: 101, gid: 101, wantOK: false},
+		{name: "uid beyond range", uid: 65537, gid: 0, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, gid, ok := ids.HostIDs(tt.uid, tt.gid)
+			if ok != tt.wantOK {
+				t.Fatalf("HostIDs() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (uid != tt.wantUID || gid != tt.wantGID) {
+				t.Errorf("HostIDs() = %d:%d, want %d:%d", uid, gid, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}
+
+func TestParseSubIDs(t *testing.T) {
+	content := `# comment
+alice:100000:65536
+bob:165536:65536
+1000:300000:1000
+alice:invalid:10
+alice:400000:0
+`
+	tests := []struct {
+		name string
+		user string
+		id   int
+		want []IDMap
+	}{
+		{
+			name: "by name",
+			user: "bob",
+			id:   1001,
+			want: []IDMap{{ContainerID: 1, HostID: 165536, Size: 65536}},
+		},
+		{
+			name: "by name and uid",
+			user: "alice",
+			id:   1000,
+			want: []IDMap{{ContainerID: 1, HostID: 100000, Size: 65536}, {ContainerID: 65537, HostID: 300000, Size: 1000}},
+		},
+		{
+			name: "no entries",
+			user: "carol",
+			id:   1002,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSubIDs(strings.NewReader(content), tt.user, tt.id)
+			if err != nil {
+				t.Fatalf("parseSubIDs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSubIDs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"errors"
 	"path/filepath"
 	"regexp"
 
@@ -8,13 +9,19 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	remote "github.com/google/go-containerregistry/pkg/v1/remote"
 	tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+	util "github.com/troppes/portable-container-engine/internal/util"
 )
 
 func RetrieveImage(imageName string, extract bool, basePath string) (string, *v1.ConfigFile, error) {
 	// Use the provided basePath instead of current working directory
 	dir := basePath
 
-	ref, img, err := download(imageName)
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, img, err := load(ref)
 	if err != nil {
 		return "", nil, err
 	}
@@ -64,6 +71,67 @@ func RetrieveImage(imageName string, extract bool, basePath string) (string, *v1
 	return savePath, configFile, nil
 }
 
+// UnpackImage makes sure all layers of the image are unpacked in the local
+// layer cache and returns their directories, lowest layer first, together
+// with the image config. ids is the user namespace mapping of the container
+// the layers are unpacked for, by unpack unless it is nil.
+func UnpackImage(imageName string, ids *IDMappings, unpack Unpacker) ([]string, *v1.ConfigFile, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, img, err := load(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layers, err := store.Layers(img, ids, unpack)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return layers, configFile, nil
+}
+
+// load returns the image from the local store, pulling it into the store
+// first if it is not there yet.
+func load(ref name.Reference) (*Store, v1.Image, error) {
+	store, err := OpenStore(util.DataRoot())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := store.Image(ref)
+	if err == nil {
+		return store, img, nil
+	}
+	if !errors.Is(err, ErrImageNotFound) {
+		return nil, nil, err
+	}
+
+	_, remoteImg, err := download(ref.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := store.Put(ref, remoteImg); err != nil {
+		return nil, nil, err
+	}
+
+	img, err = store.Image(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return store, img, nil
+}
+
 func download(imageName string) (name.Reference, v1.Image, error) {
 	ref, err := name.ParseReference(imageName)
 	if err != nil {
@@ -0,0 +1,446 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// The layer cache lives next to the image layout under <root>/layers. Every
+// layer is unpacked once per ID mapping into
+// <root>/layers/<diffid>/<mapping>/rootfs and shared by all images containing
+// it, as the owners of its files depend on the mapping. Images using a layer
+// are recorded as empty files in <root>/layers/<diffid>/refs named after the
+// image digest and containers as container-<id>, so a layer with an empty
+// refs directory is no longer used and can be garbage collected.
+
+// containerRefPrefix starts the names of the references of containers.
+const containerRefPrefix = "container-"
+
+func (s *Store) layersDir() string {
+	return filepath.Join(s.root, "layers")
+}
+
+func (s *Store) layerDir(diffID v1.Hash) string {
+	return filepath.Join(s.layersDir(), diffID.Hex)
+}
+
+// unpackedDir is the directory the layer is unpacked into for ids.
+func (s *Store) unpackedDir(diffID v1.Hash, ids *IDMappings) string {
+	return filepath.Join(s.layerDir(diffID), ids.key())
+}
+
+// Unpacker unpacks a layer tarball into dir like UnpackLayer. Only a process
+// privileged in a user namespace mapping the subordinate IDs of a mapping can
+// assign them, so the runtime unpacks such layers in one.
+type Unpacker func(r io.Reader, dir string) error
+
+// Layers makes sure every layer of img is unpacked in the layer cache and
+// returns the unpacked directories, lowest layer first. Layers missing from
+// the cache are unpacked with their ownership translated through ids, by
+// unpack or by this process if it is nil.
+func (s *Store) Layers(img v1.Image, ids *IDMappings, unpack Unpacker) ([]string, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.unpackLayer(diffID, layer, ids, unpack); err != nil {
+			return nil, err
+		}
+
+		refsDir := filepath.Join(s.layerDir(diffID), "refs")
+		if err := os.MkdirAll(refsDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(refsDir, digest.Hex), nil, 0644); err != nil {
+			return nil, fmt.Errorf("failed to reference layer %s: %v", diffID, err)
+		}
+
+		dirs = append(dirs, filepath.Join(s.unpackedDir(diffID, ids), "rootfs"))
+	}
+
+	return dirs, nil
+}
+
+// unpackLayer extracts layer into the cache unless it is already there for
+// ids. The
+// layer is unpacked and its whiteouts converted into the overlayfs format in
+// a temporary directory, which is renamed into place. A concurrent pull of
+// the same layer or an interrupted unpack never leaves a partial tree.
+func (s *Store) unpackLayer(diffID v1.Hash, layer v1.Layer, ids *IDMappings, unpack Unpacker) error {
+	dir := s.unpackedDir(diffID, ids)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.layerDir(diffID), 0755); err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(s.layerDir(diffID), ".tmp-"+ids.key()+"-")
+	if err != nil {
+		return err
+	}
+	defer RemoveAll(tmpDir)
+
+	r, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if unpack == nil {
+		unpack = func(r io.Reader, dir string) error {
+			return UnpackLayer(r, dir, ids)
+		}
+	}
+	if err := unpack(r, tmpDir); err != nil {
+		return fmt.Errorf("failed to unpack layer %s: %v", diffID, err)
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// Someone else finished unpacking the same layer first
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to move layer %s into cache: %v", diffID, err)
+	}
+
+	return nil
+}
+
+// UnpackLayer extracts the layer tarball r into dir/rootfs with its ownership
+// translated through ids and prepares it for overlayfs.
+func UnpackLayer(r io.Reader, dir string, ids *IDMappings) error {
+	if err := extractLayer(r, filepath.Join(dir, "rootfs"), ids); err != nil {
+		return err
+	}
+
+	if err := prepareOverlay(dir); err != nil {
+		return fmt.Errorf("failed to convert whiteouts: %v", err)
+	}
+	return nil
+}
+
+// prepareOverlay converts the whiteouts of the layer unpacked into dir into
+// the overlayfs format, if the filesystem supports it. Layers that cannot be
+// converted keep their OCI whiteouts and are copied instead of mounted.
+func prepareOverlay(dir string) error {
+	if !overlayWhiteoutsSupported(dir) {
+		return nil
+	}
+
+	if err := convertWhiteouts(filepath.Join(dir, "rootfs")); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, overlayFormatMarker), nil, 0644)
+}
+
+// LayerRefs returns the number of stored images and containers using the
+// layer.
+func (s *Store) LayerRefs(diffID v1.Hash) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.layerDir(diffID), "refs"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return len(entries), err
+}
+
+// RetainLayers records that the container with the given ID uses the unpacked
+// layer directories returned by Layers, which keeps them from being garbage
+// collected until ReleaseLayers.
+func RetainLayers(layers []string, container string) error {
+	for _, layer := range layers {
+		if err := os.WriteFile(containerRef(layer, container), nil, 0644); err != nil {
+			return fmt.Errorf("failed to reference layer %s: %v", layer, err)
+		}
+	}
+	return nil
+}
+
+// ReleaseLayers drops the references RetainLayers recorded for the container.
+func ReleaseLayers(layers []string, container string) error {
+	for _, layer := range layers {
+		if err := os.Remove(containerRef(layer, container)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerRef is the reference of a container on the layer unpacked in the
+// rootfs directory layer.
+func containerRef(layer, container string) string {
+	return filepath.Join(filepath.Dir(filepath.Dir(layer)), "refs", containerRefPrefix+container)
+}
+
+// releaseLayers drops the references the image with the given digest holds on
+// its layers. The image is read from its blobs, as it may already be gone
+// from the index.
+func (s *Store) releaseLayers(digest v1.Hash) error {
+	config, err := s.configFile(digest)
+	if err != nil {
+		return err
+	}
+
+	for _, diffID := range config.RootFS.DiffIDs {
+		ref := filepath.Join(s.layerDir(diffID), "refs", digest.Hex)
+		if err := os.Remove(ref); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) configFile(digest v1.Hash) (*v1.ConfigFile, error) {
+	rc, err := s.images.Blob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	manifest, err := v1.ParseManifest(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err = s.images.Blob(manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return v1.ParseConfigFile(rc)
+}
+
+// GarbageCollectLayers removes every unpacked layer that is no longer
+// referenced by a stored image or a container and returns the diff IDs it
+// removed.
+func (s *Store) GarbageCollectLayers() ([]v1.Hash, error) {
+	entries, err := os.ReadDir(s.layersDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []v1.Hash
+	for _, entry := range entries {
+		diffID, err := v1.NewHash("sha256:" + entry.Name())
+		if err != nil {
+			// Leftovers of interrupted unpacks and other foreign entries
+			continue
+		}
+
+		refs, err := s.LayerRefs(diffID)
+		if err != nil {
+			return removed, err
+		}
+		if refs > 0 {
+			continue
+		}
+
+		if err := RemoveAll(s.layerDir(diffID)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, diffID)
+	}
+
+	return removed, nil
+}
+
+// CopyLayers applies the unpacked layer directories in order onto dest,
+// producing a private, writable root filesystem. Whiteouts kept in the layers
+// remove the matching contents of the layers below. Modes, ownership,
+// timestamps and extended attributes are copied along.
+func CopyLayers(layers []string, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("CopyLayers: MkdirAll() failed: %s", err.Error())
+	}
+
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return fmt.Errorf("CopyLayers: OpenRoot() failed: %s", err.Error())
+	}
+	defer root.Close()
+
+	// Directory modes and timestamps are restored once all layers are in
+	// place
+	dirs := map[string]dirAttrs{}
+
+	for _, layer := range layers {
+		if err := copyLayer(layer, root, dirs); err != nil {
+			return err
+		}
+	}
+
+	restoreDirs(root, dirs)
+
+	return nil
+}
+
+func copyLayer(layer string, root *os.Root, dirs map[string]dirAttrs) error {
+	return filepath.WalkDir(layer, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+
+		if target == "." {
+			return clearOpaqueDir(path, root, target)
+		}
+
+		if isWhiteout(target) {
+			if filepath.Base(target) == whiteoutOpaqueDir {
+				return nil
+			}
+			return applyWhiteout(root, target, nil)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if isOverlayWhiteout(info) {
+			return root.RemoveAll(target)
+		}
+
+		// Entries of upper layers replace whatever a lower layer had at the
+		// same path, except that directories are merged
+		if existing, err := root.Lstat(target); err == nil {
+			if !(existing.IsDir() && d.IsDir()) {
+				if err := root.RemoveAll(target); err != nil {
+					return err
+				}
+			}
+		}
+
+		switch {
+		case d.IsDir():
+			if err := root.Mkdir(target, dirMode(info.Mode())); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			if err := clearOpaqueDir(path, root, target); err != nil {
+				return err
+			}
+			dirs[target] = dirAttrs{mode: info.Mode() & modeBits, mtime: info.ModTime()}
+		case d.Type()&fs.ModeSymlink != 0:
+			linkname, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := root.Symlink(linkname, target); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if err := copyFile(path, root, target); err != nil {
+				return err
+			}
+		default:
+			fmt.Printf("Warning: Skipping %s with unsupported file type %s\n", target, d.Type())
+			return nil
+		}
+
+		copyMetadata(path, info, root, target)
+		return nil
+	})
+}
+
+// clearOpaqueDir empties target if the layer directory at path is opaque. As
+// the directory is visited before its children, only lower layers' contents
+// are removed.
+func clearOpaqueDir(path string, root *os.Root, target string) error {
+	if !isOpaqueDir(path) {
+		return nil
+	}
+	return clearDir(root, target, nil)
+}
+
+// copyMetadata copies ownership, mode, modification time and extended
+// attributes of the layer entry at path onto target.
+func copyMetadata(path string, info fs.FileInfo, root *os.Root, target string) {
+	if uid, gid, ok := fileOwner(info); ok {
+		if err := root.Lchown(target, uid, gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+			fmt.Printf("Warning: Failed to set owner of %s: %s\n", target, err.Error())
+		}
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return
+	}
+
+	mode := info.Mode()
+	if mode.IsDir() {
+		mode = dirMode(mode)
+	}
+	if err := root.Chmod(target, mode&modeBits); err != nil {
+		fmt.Printf("Warning: Failed to set permissions on %s: %s\n", target, err.Error())
+	}
+
+	if !info.IsDir() {
+		if err := root.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+			fmt.Printf("Warning: Failed to set timestamps on %s: %s\n", target, err.Error())
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	for name := range xattrs {
+		// Whiteout markers only mean something within the layer
+		if strings.HasPrefix(name, overlayXattrPrefix) {
+			delete(xattrs, name)
+		}
+	}
+	if err == nil && len(xattrs) > 0 {
+		err = applyXattrs(root, target, xattrs)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to copy extended attributes to %s: %s\n", target, err.Error())
+	}
+}
+
+func copyFile(src string, root *os.Root, target string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,351 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	name "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	empty "github.com/google/go-containerregistry/pkg/v1/empty"
+	mutate "github.com/google/go-containerregistry/pkg/v1/mutate"
+	tarball "github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// layerFromFiles builds an image layer from the given tar entries
+func layerFromFiles(t *testing.T, files []tarFile) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, tf := range files {
+		if err := writeTarEntry(tw, tf); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	tw.Close()
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create layer: %v", err)
+	}
+	return layer
+}
+
+func imageFromLayers(t *testing.T, layers ...v1.Layer) v1.Image {
+	t.Helper()
+
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	return img
+}
+
+func TestLayerCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-layers-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := OpenStore(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	base := layerFromFiles(t, []tarFile{
+		{name: "etc", typeflag: tar.TypeDir, mode: 0755},
+		{name: "etc/os-release", typeflag: tar.TypeReg, content: []byte("base"), mode: 0644},
+	})
+	first := imageFromLayers(t, base, layerFromFiles(t, []tarFile{
+		{name: "first.txt", typeflag: tar.TypeReg, content: []byte("first"), mode: 0644},
+	}))
+	second := imageFromLayers(t, base, layerFromFiles(t, []tarFile{
+		{name: "second.txt", typeflag: tar.TypeReg, content: []byte("second"), mode: 0644},
+	}))
+
+	firstRef, _ := name.ParseReference("example.com/test/first:latest")
+	secondRef, _ := name.ParseReference("example.com/test/second:latest")
+	baseDiffID, _ := base.DiffID()
+
+	var firstLayers, secondLayers []string
+	for _, tc := range []struct {
+		ref    name.Reference
+		img    v1.Image
+		layers *[]string
+	}{{firstRef, first, &firstLayers}, {secondRef, second, &secondLayers}} {
+		if err := store.Put(tc.ref, tc.img); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		stored, err := store.Image(tc.ref)
+		if err != nil {
+			t.Fatalf("failed to get image: %v", err)
+		}
+		if *tc.layers, err = store.Layers(stored, SingleIDMappings(), nil); err != nil {
+			t.Fatalf("failed to unpack layers: %v", err)
+		}
+	}
+
+	t.Run("Shared layers are unpacked once", func(t *testing.T) {
+		if len(firstLayers) != 2 || len(secondLayers) != 2 {
+			t.Fatalf("expected 2 layers per image, got %d and %d", len(firstLayers), len(secondLayers))
+		}
+		if firstLayers[0] != secondLayers[0] {
+			t.Errorf("base layer not shared: %s != %s", firstLayers[0], secondLayers[0])
+		}
+		if firstLayers[1] == secondLayers[1] {
+			t.Error("distinct layers share a directory")
+		}
+
+		content, err := os.ReadFile(filepath.Join(firstLayers[0], "etc/os-release"))
+		if err != nil {
+			t.Fatalf("failed to read unpacked file: %v", err)
+		}
+		if string(content) != "base" {
+			t.Errorf("content mismatch, got %q, want %q", string(content), "base")
+		}
+
+		refs, err := store.LayerRefs(baseDiffID)
+		if err != nil {
+			t.Fatalf("failed to count refs: %v", err)
+		}
+		if refs != 2 {
+			t.Errorf("expected 2 refs on base layer, got %d", refs)
+		}
+	})
+
+	t.Run("Layers are unpacked per ID mapping", func(t *testing.T) {
+		stored, err := store.Image(firstRef)
+		if err != nil {
+			t.Fatalf("failed to get image: %v", err)
+		}
+		ids := &IDMappings{
+			UIDs: []IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+			GIDs: []IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		}
+		mapped, err := store.Layers(stored, ids, nil)
+		if err != nil {
+			t.Fatalf("failed to unpack layers: %v", err)
+		}
+		if mapped[0] == firstLayers[0] {
+			t.Error("layer unpacked for another mapping is reused")
+		}
+		again, err := store.Layers(stored, SingleIDMappings(), nil)
+		if err != nil {
+			t.Fatalf("failed to unpack layers: %v", err)
+		}
+		if again[0] != firstLayers[0] {
+			t.Errorf("layer unpacked twice for the same mapping: %s != %s", again[0], firstLayers[0])
+		}
+		if refs, _ := store.LayerRefs(baseDiffID); refs != 2 {
+			t.Errorf("expected 2 refs on base layer, got %d", refs)
+		}
+	})
+
+	t.Run("Layers are copied into a rootfs", func(t *testing.T) {
+		rootfs := filepath.Join(tmpDir, "rootfs")
+		if err := CopyLayers(firstLayers, rootfs); err != nil {
+			t.Fatalf("failed to copy layers: %v", err)
+		}
+
+		for _, file := range []string{"etc/os-release", "first.txt"} {
+			if _, err := os.Stat(filepath.Join(rootfs, file)); err != nil {
+				t.Errorf("expected %s in rootfs: %v", file, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(rootfs, "second.txt")); !os.IsNotExist(err) {
+			t.Error("file of another image ended up in rootfs")
+		}
+
+		// Writing into the rootfs must not touch the cache
+		if err := os.WriteFile(filepath.Join(rootfs, "etc/os-release"), []byte("changed"), 0644); err != nil {
+			t.Fatalf("failed to write rootfs file: %v", err)
+		}
+		content, _ := os.ReadFile(filepath.Join(firstLayers[0], "etc/os-release"))
+		if string(content) != "base" {
+			t.Error("writing to the rootfs modified the layer cache")
+		}
+	})
+
+	t.Run("Unused layers are garbage collected", func(t *testing.T) {
+		if err := store.Remove(firstRef); err != nil {
+			t.Fatalf("failed to remove image: %v", err)
+		}
+
+		removed, err := store.GarbageCollectLayers()
+		if err != nil {
+			t.Fatalf("failed to garbage collect: %v", err)
+		}
+		if len(removed) != 1 {
+			t.Fatalf("expected 1 removed layer, got %d", len(removed))
+		}
+		if _, err := os.Stat(firstLayers[1]); !os.IsNotExist(err) {
+			t.Error("unused layer still exists")
+		}
+
+		for _, dir := range secondLayers {
+			if _, err := os.Stat(dir); err != nil {
+				t.Errorf("layer %s in use was removed: %v", dir, err)
+			}
+		}
+		if refs, _ := store.LayerRefs(baseDiffID); refs != 1 {
+			t.Errorf("expected 1 ref on base layer, got %d", refs)
+		}
+	})
+
+	t.Run("Layers of containers are kept", func(t *testing.T) {
+		if err := RetainLayers(secondLayers, "c1"); err != nil {
+			t.Fatalf("failed to retain layers: %v", err)
+		}
+		if err := store.Remove(secondRef); err != nil {
+			t.Fatalf("failed to remove image: %v", err)
+		}
+
+		removed, err := store.GarbageCollectLayers()
+		if err != nil {
+			t.Fatalf("failed to garbage collect: %v", err)
+		}
+		if len(removed) != 0 {
+			t.Errorf("layers of a container were removed: %v", removed)
+		}
+		if refs, _ := store.LayerRefs(baseDiffID); refs != 1 {
+			t.Errorf("expected 1 ref on base layer, got %d", refs)
+		}
+
+		if err := ReleaseLayers(secondLayers, "c1"); err != nil {
+			t.Fatalf("failed to release layers: %v", err)
+		}
+		if removed, err = store.GarbageCollectLayers(); err != nil {
+			t.Fatalf("failed to garbage collect: %v", err)
+		}
+		if len(removed) != 2 {
+			t.Errorf("expected 2 removed layers, got %d", len(removed))
+		}
+	})
+}
+
+func TestLayerCacheOverlayFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	defer RemoveAll(tmpDir)
+	if !overlayWhiteoutsSupported(tmpDir) {
+		t.Skip("overlay whiteouts not supported")
+	}
+
+	store, err := OpenStore(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	layers, err := store.Layers(imageFromLayers(t, layerFromFiles(t, []tarFile{
+		{name: "etc", typeflag: tar.TypeDir, mode: 0555},
+		{name: "etc/.wh.removed.txt", typeflag: tar.TypeReg, mode: 0644},
+	})), SingleIDMappings(), nil)
+	if err != nil {
+		t.Fatalf("failed to unpack layers: %v", err)
+	}
+
+	// The layer only shows up in the cache once it is converted
+	if !OverlayCompatible(layers) {
+		t.Error("cached layer is not in the overlay format")
+	}
+	info, err := os.Lstat(filepath.Join(layers[0], "etc/removed.txt"))
+	if err != nil || !isOverlayWhiteout(info) {
+		t.Errorf("expected overlay whiteout for etc/removed.txt: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(layers[0], "etc")); err != nil || info.Mode() != 0555|os.ModeDir {
+		t.Errorf("etc lost its mode during the conversion: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(filepath.Dir(layers[0])))
+	if err != nil {
+		t.Fatalf("failed to read layer cache: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			t.Errorf("layer cache kept the temporary directory %s", entry.Name())
+		}
+	}
+}
+
+func TestCopyLayersWhiteouts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-copy-whiteout-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Same layers as in TestExtractImageWhiteouts, unpacked one by one
+	layers := []string{filepath.Join(tmpDir, "lower"), filepath.Join(tmpDir, "upper")}
+	for i, files := range [][]tarFile{
+		{
+			{name: "etc", typeflag: tar.TypeDir, mode: 0755},
+			{name: "etc/keep.txt", typeflag: tar.TypeReg, content: []byte("keep"), mode: 0644},
+			{name: "etc/removed.txt", typeflag: tar.TypeReg, content: []byte("removed"), mode: 0644},
+			{name: "cache", typeflag: tar.TypeDir, mode: 0755},
+			{name: "cache/old.txt", typeflag: tar.TypeReg, content: []byte("old"), mode: 0644},
+			{name: "cache/sub", typeflag: tar.TypeDir, mode: 0755},
+			{name: "cache/sub/old.txt", typeflag: tar.TypeReg, content: []byte("old"), mode: 0644},
+		},
+		{
+			{name: "etc/.wh.removed.txt", typeflag: tar.TypeReg, mode: 0644},
+			{name: "cache", typeflag: tar.TypeDir, mode: 0755},
+			{name: "cache/new.txt", typeflag: tar.TypeReg, content: []byte("new"), mode: 0644},
+			{name: "cache/.wh..wh..opq", typeflag: tar.TypeReg, mode: 0644},
+		},
+	} {
+		r, err := layerFromFiles(t, files).Uncompressed()
+		if err != nil {
+			t.Fatalf("failed to read layer: %v", err)
+		}
+		if err := extractLayer(r, layers[i], SingleIDMappings()); err != nil {
+			t.Fatalf("failed to extract layer: %v", err)
+		}
+		r.Close()
+	}
+
+	// The cached layer keeps its markers so it can be stacked later
+	if _, err := os.Stat(filepath.Join(layers[1], "cache", whiteoutOpaqueDir)); err != nil {
+		t.Errorf("opaque marker missing from unpacked layer: %v", err)
+	}
+
+	rootfs := filepath.Join(tmpDir, "rootfs")
+	if err := CopyLayers(layers, rootfs); err != nil {
+		t.Fatalf("failed to copy layers: %v", err)
+	}
+
+	checkWhiteouts(t, rootfs)
+
+	t.Run("Overlay whiteouts", func(t *testing.T) {
+		if !overlayWhiteoutsSupported(tmpDir) {
+			t.Skip("overlay whiteouts not supported")
+		}
+
+		if err := convertWhiteouts(layers[1]); err != nil {
+			t.Fatalf("failed to convert whiteouts: %v", err)
+		}
+
+		info, err := os.Lstat(filepath.Join(layers[1], "etc/removed.txt"))
+		if err != nil || !isOverlayWhiteout(info) {
+			t.Errorf("expected overlay whiteout for etc/removed.txt: %v", err)
+		}
+		if !isOpaqueDir(filepath.Join(layers[1], "cache")) {
+			t.Error("expected cache to be marked opaque")
+		}
+
+		rootfs := filepath.Join(tmpDir, "overlay-rootfs")
+		if err := CopyLayers(layers, rootfs); err != nil {
+			t.Fatalf("failed to copy layers: %v", err)
+		}
+
+		checkWhiteouts(t, rootfs)
+		if isOpaqueDir(filepath.Join(rootfs, "cache")) {
+			t.Error("opaque marker was copied into the rootfs")
+		}
+	})
+}
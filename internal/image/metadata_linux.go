@@ -0,0 +1,83 @@
+//go:build linux
+
+package image
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattrs applies extended attributes, such as security.capability, to f.
+func setXattrs(f *os.File, xattrs map[string]string) error {
+	for name, value := range xattrs {
+		if err := unix.Fsetxattr(int(f.Fd()), name, []byte(value), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readXattrs returns the extended attributes of the file at path without
+// following symlinks.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for start := 0; start < size; {
+		end := start
+		for end < size && buf[end] != 0 {
+			end++
+		}
+		name := string(buf[start:end])
+		start = end + 1
+
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		if _, err := unix.Lgetxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		xattrs[name] = string(value)
+	}
+
+	return xattrs, nil
+}
+
+// fileOwner returns the host uid and gid owning the file described by info.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// makeWhiteout creates an overlayfs whiteout at path.
+func makeWhiteout(path string) error {
+	return unix.Mknod(path, unix.S_IFCHR, 0)
+}
+
+// setOpaque marks the directory at path as opaque for overlayfs.
+func setOpaque(path string) error {
+	return unix.Lsetxattr(path, overlayOpaqueXattr, []byte("y"), 0)
+}
+
+// isOverlayWhiteout reports whether info describes an overlayfs whiteout.
+func isOverlayWhiteout(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&fs.ModeCharDevice != 0 && stat.Rdev == 0
+}
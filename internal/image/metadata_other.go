@@ -0,0 +1,36 @@
+//go:build !linux
+
+package image
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// Extended attributes and ownership are only reproduced on Linux, the only
+// platform containers are run on.
+
+func setXattrs(f *os.File, xattrs map[string]string) error {
+	return nil
+}
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
+
+func makeWhiteout(path string) error {
+	return errors.New("overlay whiteouts are only supported on Linux")
+}
+
+func setOpaque(path string) error {
+	return errors.New("overlay whiteouts are only supported on Linux")
+}
+
+func isOverlayWhiteout(info fs.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,164 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	name "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	empty "github.com/google/go-containerregistry/pkg/v1/empty"
+	layout "github.com/google/go-containerregistry/pkg/v1/layout"
+	match "github.com/google/go-containerregistry/pkg/v1/match"
+)
+
+// refNameAnnotation is the OCI annotation used to index manifests by reference.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+var ErrImageNotFound = errors.New("image not found in store")
+
+// Store is a persistent, content-addressable image store. Images are kept as
+// an OCI image layout under <root>/images: blobs are stored by digest and
+// manifests are indexed by their fully qualified reference in index.json.
+type Store struct {
+	root   string
+	images layout.Path
+}
+
+func OpenStore(root string) (*Store, error) {
+	imagesDir := filepath.Join(root, "images")
+
+	images, err := layout.FromPath(imagesDir)
+	if os.IsNotExist(err) {
+		images, err = layout.Write(imagesDir, empty.Index)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image store at %s: %v", imagesDir, err)
+	}
+
+	return &Store{root: root, images: images}, nil
+}
+
+// Image returns the image stored for ref or ErrImageNotFound.
+func (s *Store) Image(ref name.Reference) (v1.Image, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	desc, err := s.descriptor(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.images.Image(desc.Digest)
+}
+
+func (s *Store) descriptor(ref name.Reference) (*v1.Descriptor, error) {
+	manifest, err := s.indexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := match.Name(ref.Name())
+	for _, desc := range manifest.Manifests {
+		if matcher(desc) {
+			return &desc, nil
+		}
+	}
+
+	return nil, ErrImageNotFound
+}
+
+func (s *Store) indexManifest() (*v1.IndexManifest, error) {
+	index, err := s.images.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.IndexManifest()
+}
+
+// lock locks the image layout until unlock is called, exclusively to change
+// it. Every change rewrites index.json, concurrent pulls would lose each
+// other's references and readers could see a partly written index otherwise.
+func (s *Store) lock(exclusive bool) (unlock func(), err error) {
+	f, err := os.Open(string(s.images))
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock image store: %v", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// Put writes all blobs of img to the store and points ref at its manifest,
+// replacing whatever ref pointed at before.
+func (s *Store) Put(ref name.Reference, img v1.Image) error {
+	// Blobs are content addressed and written before taking the lock, so a
+	// download does not hold up other pulls
+	if err := s.images.WriteImage(img); err != nil {
+		return err
+	}
+
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	previous, err := s.descriptor(ref)
+	if err != nil && !errors.Is(err, ErrImageNotFound) {
+		return err
+	}
+
+	annotations := map[string]string{refNameAnnotation: ref.Name()}
+	if err := s.images.ReplaceImage(img, match.Name(ref.Name()), layout.WithAnnotations(annotations)); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		return s.releaseIfUnused(previous.Digest)
+	}
+	return nil
+}
+
+// Remove deletes ref from the store. Layers only used by the removed image
+// lose their reference and are cleaned up by GarbageCollectLayers.
+func (s *Store) Remove(ref name.Reference) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	desc, err := s.descriptor(ref)
+	if err != nil {
+		return err
+	}
+
+	if err := s.images.RemoveDescriptors(match.Name(ref.Name())); err != nil {
+		return err
+	}
+
+	return s.releaseIfUnused(desc.Digest)
+}
+
+// releaseIfUnused releases the layers of the image with the given digest once
+// no reference in the index points at it anymore.
+func (s *Store) releaseIfUnused(digest v1.Hash) error {
+	manifest, err := s.indexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range manifest.Manifests {
+		if desc.Digest == digest {
+			return nil
+		}
+	}
+
+	return s.releaseLayers(digest)
+}
@@ -0,0 +1,137 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	name "github.com/google/go-containerregistry/pkg/name"
+	random "github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestStore(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-store-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := OpenStore(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	ref, err := name.ParseReference("example.com/test/image:latest")
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+
+	t.Run("Missing image is not found", func(t *testing.T) {
+		if _, err := store.Image(ref); !errors.Is(err, ErrImageNotFound) {
+			t.Errorf("expected ErrImageNotFound, got %v", err)
+		}
+	})
+
+	first, err := random.Image(512, 2)
+	if err != nil {
+		t.Fatalf("failed to create random image: %v", err)
+	}
+
+	t.Run("Stored image can be read back", func(t *testing.T) {
+		if err := store.Put(ref, first); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+
+		// Reopen to make sure the index was persisted
+		reopened, err := OpenStore(tmpDir)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+
+		img, err := reopened.Image(ref)
+		if err != nil {
+			t.Fatalf("failed to get image: %v", err)
+		}
+
+		want, _ := first.Digest()
+		got, err := img.Digest()
+		if err != nil {
+			t.Fatalf("failed to get digest: %v", err)
+		}
+		if got != want {
+			t.Errorf("digest mismatch, got %v, want %v", got, want)
+		}
+
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("failed to get layers: %v", err)
+		}
+		if len(layers) != 2 {
+			t.Errorf("expected 2 layers, got %d", len(layers))
+		}
+	})
+
+	t.Run("Putting a reference again replaces it", func(t *testing.T) {
+		second, err := random.Image(512, 1)
+		if err != nil {
+			t.Fatalf("failed to create random image: %v", err)
+		}
+		if err := store.Put(ref, second); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+
+		img, err := store.Image(ref)
+		if err != nil {
+			t.Fatalf("failed to get image: %v", err)
+		}
+
+		want, _ := second.Digest()
+		got, _ := img.Digest()
+		if got != want {
+			t.Errorf("digest mismatch, got %v, want %v", got, want)
+		}
+
+		index, _ := store.images.ImageIndex()
+		manifest, _ := index.IndexManifest()
+		if len(manifest.Manifests) != 1 {
+			t.Errorf("expected 1 manifest in index, got %d", len(manifest.Manifests))
+		}
+	})
+
+	t.Run("Concurrent puts keep every reference", func(t *testing.T) {
+		refs := make([]name.Reference, 32)
+		for i := range refs {
+			refs[i], _ = name.ParseReference(fmt.Sprintf("example.com/test/concurrent:%d", i))
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(refs))
+		for _, ref := range refs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Separate stores, like separate pce processes
+				s, err := OpenStore(tmpDir)
+				if err == nil {
+					err = s.Put(ref, first)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("failed to put image: %v", err)
+			}
+		}
+
+		for _, ref := range refs {
+			if _, err := store.Image(ref); err != nil {
+				t.Errorf("reference %s lost: %v", ref, err)
+			}
+		}
+	})
+}
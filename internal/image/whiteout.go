@@ -0,0 +1,163 @@
+package image
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OCI whiteouts: an empty file named .wh.<name> deletes <name> from the
+// layers below, and a .wh..wh..opq file hides all lower contents of the
+// directory it is placed in.
+// https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = ".wh..wh."
+	whiteoutOpaqueDir  = ".wh..wh..opq"
+)
+
+// overlayfs uses its own whiteout format: a deleted entry is a 0/0 character
+// device and an opaque directory carries an xattr, which lives in the user
+// namespace for unprivileged mounts with the userxattr option.
+const (
+	overlayOpaqueXattr  = "user.overlay.opaque"
+	overlayXattrPrefix  = "user.overlay."
+	overlayFormatMarker = "overlay"
+)
+
+func isWhiteout(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), whiteoutPrefix)
+}
+
+// applyWhiteout applies the whiteout entry at path to the tree in root. Paths
+// in keep were written by the layer the whiteout belongs to and survive an
+// opaque whiteout.
+func applyWhiteout(root *os.Root, path string, keep map[string]bool) error {
+	dir, base := filepath.Dir(path), filepath.Base(path)
+
+	switch {
+	case base == whiteoutOpaqueDir:
+		return clearDir(root, dir, keep)
+	case strings.HasPrefix(base, whiteoutMetaPrefix):
+		// Other AUFS metadata files carry no meaning for us
+		return nil
+	default:
+		return root.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+	}
+}
+
+// clearDir removes everything below dir that is not in keep.
+func clearDir(root *os.Root, dir string, keep map[string]bool) error {
+	f, err := root.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !keep[path] {
+			if err := root.RemoveAll(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.IsDir() {
+			if err := clearDir(root, path, keep); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// convertWhiteouts rewrites the OCI whiteouts of an unpacked layer into the
+// overlayfs format, so the layer can be used as an overlay lower directory.
+func convertWhiteouts(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isWhiteout(path) {
+			return nil
+		}
+
+		parent, base := filepath.Dir(path), filepath.Base(path)
+		restore := makeWritable(parent)
+		defer restore()
+
+		switch {
+		case base == whiteoutOpaqueDir:
+			err = setOpaque(parent)
+		case strings.HasPrefix(base, whiteoutMetaPrefix):
+		default:
+			err = makeWhiteout(filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix)))
+		}
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+
+		return os.Remove(path)
+	})
+}
+
+// makeWritable lets an unprivileged user change the directory at path, which
+// keeps the mode of the image. The returned function restores its mode and
+// modification time.
+func makeWritable(path string) func() {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return func() {}
+	}
+	if os.Geteuid() != 0 {
+		os.Chmod(path, dirMode(info.Mode()))
+	}
+	return func() {
+		os.Chmod(path, info.Mode()&modeBits)
+		os.Chtimes(path, info.ModTime(), info.ModTime())
+	}
+}
+
+// overlayWhiteoutsSupported checks whether overlay whiteouts can be created
+// in dir. This needs Linux 5.8 or newer and user xattr support of the
+// underlying filesystem.
+func overlayWhiteoutsSupported(dir string) bool {
+	probe, err := os.MkdirTemp(dir, ".probe-")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(probe)
+
+	return setOpaque(probe) == nil && makeWhiteout(filepath.Join(probe, "whiteout")) == nil
+}
+
+// isOpaqueDir reports whether the layer directory at path hides the contents
+// of lower layers, in either whiteout format.
+func isOpaqueDir(path string) bool {
+	if _, err := os.Lstat(filepath.Join(path, whiteoutOpaqueDir)); err == nil {
+		return true
+	}
+	xattrs, _ := readXattrs(path)
+	return xattrs[overlayOpaqueXattr] == "y"
+}
+
+// OverlayCompatible reports whether all unpacked layers use the overlayfs
+// whiteout format and can be stacked with an overlay mount.
+func OverlayCompatible(layers []string) bool {
+	for _, layer := range layers {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(layer), overlayFormatMarker)); err != nil {
+			return false
+		}
+	}
+	return true
+}
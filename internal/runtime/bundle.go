@@ -0,0 +1,382 @@
+package runtime
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	img "github.com/troppes/portable-container-engine/internal/image"
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+	"github.com/troppes/portable-container-engine/internal/spec"
+)
+
+// defaultMaskedPaths expose information about the host or let containers
+// affect it and are hidden like other runtimes do: directories below an
+// empty tmpfs, files below /dev/null.
+var defaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/interrupts",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/sys/devices/virtual/powercap",
+	"/sys/firmware",
+}
+
+// defaultReadonlyPaths are kernel interfaces containers may read but not
+// change.
+var defaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// systemMounts are the file systems pce sets up in every container itself,
+// so the mounts of a bundle to their destinations are skipped.
+var systemMounts = []spec.Mount{
+	{Destination: "/proc", Type: "proc", Source: "proc", Options: []string{"nosuid", "noexec", "nodev"}},
+	{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+	{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+	{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+	{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+	{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+}
+
+// rlimitTypes are the resource limits a bundle can set.
+var rlimitTypes = map[string]int{
+	"RLIMIT_CPU":        0,
+	"RLIMIT_FSIZE":      1,
+	"RLIMIT_DATA":       2,
+	"RLIMIT_STACK":      3,
+	"RLIMIT_CORE":       4,
+	"RLIMIT_RSS":        5,
+	"RLIMIT_NPROC":      6,
+	"RLIMIT_NOFILE":     7,
+	"RLIMIT_MEMLOCK":    8,
+	"RLIMIT_AS":         9,
+	"RLIMIT_LOCKS":      10,
+	"RLIMIT_SIGPENDING": 11,
+	"RLIMIT_MSGQUEUE":   12,
+	"RLIMIT_NICE":       13,
+	"RLIMIT_RTPRIO":     14,
+	"RLIMIT_RTTIME":     15,
+}
+
+// defaultHostname is the hostname of containers not created from a bundle.
+const defaultHostname = "container"
+
+// containerEnv applies the options of a container on top of the environment
+// of its image. The environment of pce is not passed on.
+func containerEnv(imageEnv []string, opts RunOptions) []string {
+	defaults := []string{"HOSTNAME=" + defaultHostname}
+	if opts.TTY {
+		defaults = append(defaults, "TERM=xterm")
+	}
+	return mergeEnv([]string{"PATH=" + defaultPath}, imageEnv, defaults, opts.Env)
+}
+
+// generateSpec describes a container of the image with the options as an
+// OCI runtime spec, for a bundle with the root filesystem in rootfs. Volumes
+// must already be resolved to their directory on the host, user is the
+// user of the image resolved in its root filesystem, ids the mappings of the
+// user namespace the root filesystem was unpacked for and profile the seccomp
+// profile resolved for the capabilities of the container, nil without one.
+func generateSpec(config v1.Config, command []string, opts RunOptions, user *execUser, ids *img.IDMappings, profile *seccomp.Profile) (*spec.Spec, error) {
+	caps, err := opts.capabilities()
+	if err != nil {
+		return nil, err
+	}
+	capabilities := &spec.Capabilities{Bounding: caps, Effective: caps, Permitted: caps}
+	if user.UID != 0 {
+		// Like with pce, users other than root keep the added capabilities
+		ambient, _ := opts.ambientCapabilities()
+		capabilities.Effective, capabilities.Permitted = ambient, ambient
+		capabilities.Inheritable, capabilities.Ambient = ambient, ambient
+	}
+
+	cwd := config.WorkingDir
+	if cwd == "" {
+		cwd = "/"
+	}
+	s := &spec.Spec{
+		Version: spec.Version,
+		Process: &spec.Process{
+			Terminal:        opts.TTY,
+			User:            spec.User{UID: user.UID, GID: user.GID, AdditionalGids: user.Groups},
+			Args:            command,
+			Env:             containerEnv(config.Env, opts),
+			Cwd:             cwd,
+			Capabilities:    capabilities,
+			NoNewPrivileges: !opts.NewPrivileges,
+		},
+		Root:     &spec.Root{Path: "rootfs", Readonly: opts.ReadOnly},
+		Hostname: defaultHostname,
+		Mounts:   slices.Clone(systemMounts),
+		Linux: &spec.Linux{
+			UIDMappings:   specIDMappings(ids.UIDs),
+			GIDMappings:   specIDMappings(ids.GIDs),
+			Seccomp:       profile,
+			MaskedPaths:   defaultMaskedPaths,
+			ReadonlyPaths: defaultReadonlyPaths,
+		},
+	}
+
+	for _, m := range opts.Mounts {
+		sm := spec.Mount{Destination: m.Destination, Type: "bind", Source: m.Source, Options: []string{"rbind"}}
+		if m.Type == MountTmpfs {
+			sm = spec.Mount{Destination: m.Destination, Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "nodev"}}
+			if m.Options != "" {
+				sm.Options = append(sm.Options, strings.Split(m.Options, ",")...)
+			}
+		}
+		if m.ReadOnly {
+			sm.Options = append(sm.Options, "ro")
+		}
+		if m.NoSuid && m.Type != MountTmpfs {
+			sm.Options = append(sm.Options, "nosuid")
+		}
+		if m.NoDev && m.Type != MountTmpfs {
+			sm.Options = append(sm.Options, "nodev")
+		}
+		if m.NoExec {
+			sm.Options = append(sm.Options, "noexec")
+		}
+		s.Mounts = append(s.Mounts, sm)
+	}
+
+	namespaces := []string{spec.PIDNamespace, spec.MountNamespace, spec.UTSNamespace, spec.UserNamespace}
+	if opts.Network == NetworkPrivate {
+		namespaces = append(namespaces, spec.NetworkNamespace)
+	}
+	if opts.privateIPC() {
+		namespaces = append(namespaces, spec.IPCNamespace)
+	}
+	if opts.privateCgroupNS() {
+		namespaces = append(namespaces, spec.CgroupNamespace)
+	}
+	if opts.privateTimeNS() {
+		namespaces = append(namespaces, spec.TimeNamespace)
+	}
+	for _, typ := range namespaces {
+		s.Linux.Namespaces = append(s.Linux.Namespaces, spec.Namespace{Type: typ})
+	}
+
+	r := opts.Resources
+	if r != (Resources{}) {
+		s.Linux.Resources = &spec.Resources{}
+		if r.Memory > 0 {
+			s.Linux.Resources.Memory = &spec.MemoryResources{Limit: &r.Memory}
+		}
+		if r.CPUs > 0 || r.CPUShares > 0 {
+			cpu := &spec.CPUResources{}
+			if r.CPUs > 0 {
+				quota, period := int64(r.CPUs*cgroupPeriod), uint64(cgroupPeriod)
+				cpu.Quota, cpu.Period = &quota, &period
+			}
+			if r.CPUShares > 0 {
+				shares := uint64(r.CPUShares)
+				cpu.Shares = &shares
+			}
+			s.Linux.Resources.CPU = cpu
+		}
+		if r.PidsLimit > 0 {
+			s.Linux.Resources.Pids = &spec.PidsResources{Limit: r.PidsLimit}
+		}
+	}
+	return s, nil
+}
+
+// specIDMappings converts ID mappings to those of a spec.
+func specIDMappings(maps []img.IDMap) []spec.IDMapping {
+	var mappings []spec.IDMapping
+	for _, m := range maps {
+		mappings = append(mappings, spec.IDMapping{ContainerID: uint32(m.ContainerID), HostID: uint32(m.HostID), Size: uint32(m.Size)})
+	}
+	return mappings
+}
+
+// checkBundleIDMappings rejects a bundle whose user namespace maps IDs other
+// than ids, the mappings pce sets up for the user. Bundles without mappings
+// get those of pce.
+func checkBundleIDMappings(s *spec.Spec, ids *img.IDMappings) error {
+	if s.Linux == nil {
+		return nil
+	}
+	for _, m := range []struct {
+		kind        string
+		bundle, pce []spec.IDMapping
+	}{
+		{"uid", s.Linux.UIDMappings, specIDMappings(ids.UIDs)},
+		{"gid", s.Linux.GIDMappings, specIDMappings(ids.GIDs)},
+	} {
+		if len(m.bundle) > 0 && !slices.Equal(m.bundle, m.pce) {
+			return fmt.Errorf("the %sMappings %s of the bundle differ from %s, which pce maps for this user", m.kind, formatIDMappings(m.bundle), formatIDMappings(m.pce))
+		}
+	}
+	return nil
+}
+
+// formatIDMappings formats mappings as containerID:hostID:size.
+func formatIDMappings(mappings []spec.IDMapping) string {
+	var s []string
+	for _, m := range mappings {
+		s = append(s, fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// bundleOptions returns the options of a container created from the spec
+// of the bundle in dir, on top of the name given in opts. pce always creates
+// a PID, mount, UTS and user namespace, the others are private if the spec
+// lists them.
+func bundleOptions(s *spec.Spec, dir string, opts RunOptions) (RunOptions, error) {
+	o := RunOptions{
+		Name:          opts.Name,
+		Bundle:        dir,
+		Network:       NetworkHost,
+		IPC:           NamespaceHost,
+		CgroupNS:      NamespaceHost,
+		TimeNS:        NamespaceHost,
+		NewPrivileges: !s.Process.NoNewPrivileges,
+		TTY:           s.Process.Terminal,
+		ReadOnly:      s.Root.Readonly,
+	}
+
+	var namespaces []spec.Namespace
+	if s.Linux != nil {
+		namespaces = s.Linux.Namespaces
+	}
+	seen := map[string]bool{}
+	for _, ns := range namespaces {
+		if ns.Path != "" {
+			return o, fmt.Errorf("joining the %s namespace at %s is not supported", ns.Type, ns.Path)
+		}
+		seen[ns.Type] = true
+		switch ns.Type {
+		case spec.PIDNamespace, spec.MountNamespace, spec.UTSNamespace, spec.UserNamespace:
+		case spec.NetworkNamespace:
+			o.Network = NetworkPrivate
+		case spec.IPCNamespace:
+			o.IPC = NamespacePrivate
+		case spec.CgroupNamespace:
+			o.CgroupNS = NamespacePrivate
+		case spec.TimeNamespace:
+			o.TimeNS = NamespacePrivate
+		default:
+			return o, fmt.Errorf("unknown namespace type %q", ns.Type)
+		}
+	}
+	for _, typ := range []string{spec.PIDNamespace, spec.MountNamespace, spec.UTSNamespace} {
+		if !seen[typ] {
+			return o, fmt.Errorf("sharing the %s namespace of the host is not supported", typ)
+		}
+	}
+
+	for _, m := range s.Mounts {
+		mount, ok, err := bundleMount(m, dir)
+		if err != nil {
+			return o, err
+		}
+		if ok {
+			o.Mounts = append(o.Mounts, mount)
+		}
+	}
+
+	if s.Linux != nil && s.Linux.Resources != nil {
+		r := s.Linux.Resources
+		if r.Memory != nil && r.Memory.Limit != nil {
+			o.Resources.Memory = *r.Memory.Limit
+		}
+		if r.CPU != nil {
+			if r.CPU.Quota != nil && *r.CPU.Quota > 0 {
+				period := uint64(cgroupPeriod)
+				if r.CPU.Period != nil && *r.CPU.Period > 0 {
+					period = *r.CPU.Period
+				}
+				o.Resources.CPUs = float64(*r.CPU.Quota) / float64(period)
+			}
+			if r.CPU.Shares != nil {
+				o.Resources.CPUShares = int64(*r.CPU.Shares)
+			}
+		}
+		if r.Pids != nil && r.Pids.Limit > 0 {
+			o.Resources.PidsLimit = r.Pids.Limit
+		}
+	}
+
+	for _, l := range s.Process.Rlimits {
+		if _, ok := rlimitTypes[l.Type]; !ok {
+			return o, fmt.Errorf("unknown rlimit %q", l.Type)
+		}
+	}
+	if caps := s.Process.Capabilities; caps != nil {
+		for _, name := range slices.Concat(caps.Bounding, caps.Ambient) {
+			if c, err := parseCapability(name); err != nil || c == allCapabilitiesName {
+				return o, fmt.Errorf("unknown capability %q", name)
+			}
+		}
+	}
+	return o, nil
+}
+
+// bundleMount converts a mount of a bundle. Mounts pce sets up itself are
+// skipped, like the cgroup file system runc adds to its default spec, which
+// pce does not mount.
+func bundleMount(m spec.Mount, dir string) (Mount, bool, error) {
+	dest := filepath.Clean(m.Destination)
+	for _, sm := range systemMounts {
+		if dest == sm.Destination {
+			return Mount{}, false, nil
+		}
+	}
+	if m.Type == "cgroup" || m.Type == "cgroup2" {
+		return Mount{}, false, nil
+	}
+
+	mount := Mount{Destination: dest}
+	var data []string
+	for _, o := range m.Options {
+		switch o {
+		case "ro":
+			mount.ReadOnly = true
+		case "nosuid":
+			mount.NoSuid = true
+		case "nodev":
+			mount.NoDev = true
+		case "noexec":
+			mount.NoExec = true
+		case "bind", "rbind":
+			mount.Type = MountBind
+		case "rw", "relatime", "strictatime", "noatime", "private", "rprivate", "slave", "rslave":
+			// pce mounts volumes with its own flags
+		default:
+			data = append(data, o)
+		}
+	}
+
+	switch {
+	case mount.Type == MountBind || m.Type == "bind":
+		mount.Type = MountBind
+		mount.Source = m.Source
+		if !filepath.IsAbs(mount.Source) {
+			mount.Source = filepath.Join(dir, mount.Source)
+		}
+	case m.Type == "tmpfs":
+		mount.Type = MountTmpfs
+		mount.Options = strings.Join(data, ",")
+		// pce mounts every tmpfs nosuid and nodev
+		mount.NoSuid, mount.NoDev = false, false
+	default:
+		return Mount{}, false, fmt.Errorf("mount of type %q at %s is not supported", m.Type, m.Destination)
+	}
+	return mount, true, nil
+}
@@ -0,0 +1,249 @@
+//go:build linux
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	img "github.com/troppes/portable-container-engine/internal/image"
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+	"github.com/troppes/portable-container-engine/internal/spec"
+)
+
+// Spec writes an OCI bundle for a container of the image with the options
+// to opts.Bundle: the root filesystem of the image in rootfs and its config
+// in config.json. An existing root filesystem is kept.
+func (r *platformRuntime) Spec(image string, command []string, opts RunOptions) error {
+	switch opts.Network {
+	case "", NetworkHost, NetworkPrivate:
+	default:
+		return fmt.Errorf("unknown network mode %q, use %s or %s", opts.Network, NetworkHost, NetworkPrivate)
+	}
+	if err := opts.validateNamespaces(); err != nil {
+		return err
+	}
+	if err := opts.Resources.validate(); err != nil {
+		return err
+	}
+	if len(opts.Ports) > 0 {
+		return fmt.Errorf("publishing ports is not supported in bundles")
+	}
+	if err := validateMounts(opts.Mounts); err != nil {
+		return err
+	}
+	caps, err := opts.capabilities()
+	if err != nil {
+		return err
+	}
+	var profile *seccomp.Profile
+	if p := opts.seccompProfile(); p != nil {
+		if profile, err = seccomp.Resolve(p, caps); err != nil {
+			return err
+		}
+	}
+
+	dir := opts.Bundle
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := os.Stat(filepath.Join(dir, spec.ConfigFile)); err == nil {
+		return fmt.Errorf("%s already exists in %s", spec.ConfigFile, dir)
+	}
+
+	ids, _ := idMappings()
+	layers, imageConfig, err := img.UnpackImage(image, ids, layerUnpacker(ids))
+	if err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		command = getDefaultCommand(imageConfig)
+		if len(command) == 0 {
+			return fmt.Errorf("no command specified and no default command found in image")
+		}
+	}
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return fmt.Errorf("failed to create bundle: %v", err)
+	}
+	if entries, err := os.ReadDir(rootfs); err == nil && len(entries) == 0 {
+		if err := copyLayers(ids, layers, rootfs); err != nil {
+			return fmt.Errorf("failed to copy root filesystem: %v", err)
+		}
+	}
+
+	user, err := rootfsUser(rootfs, imageConfig.Config.User)
+	if err != nil {
+		return err
+	}
+	for i, m := range opts.Mounts {
+		if m.Type == MountVolume {
+			if opts.Mounts[i].Source, err = volumePath(m.Source); err != nil {
+				return err
+			}
+		}
+	}
+
+	s, err := generateSpec(imageConfig.Config, command, opts, user, ids, profile)
+	if err != nil {
+		return err
+	}
+	return s.Save(dir)
+}
+
+// rootfsUser resolves the user of an image in its root filesystem.
+func rootfsUser(rootfs, user string) (*execUser, error) {
+	root, err := os.OpenRoot(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	var files [2]io.Reader
+	for i, name := range []string{"etc/passwd", "etc/group"} {
+		f, err := root.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	return resolveUser(user, files[0], files[1])
+}
+
+// createFromBundle creates a container running the bundle in opts.Bundle
+// in place. Only the name of opts applies, everything else is taken from
+// the config of the bundle.
+func createFromBundle(opts RunOptions) (*Container, error) {
+	dir, err := filepath.Abs(opts.Bundle)
+	if err != nil {
+		return nil, err
+	}
+	s, err := spec.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	o, err := bundleOptions(s, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	ids, _ := idMappings()
+	if err := checkBundleIDMappings(s, ids); err != nil {
+		return nil, err
+	}
+	if !s.HasNamespace(spec.UserNamespace) {
+		fmt.Fprintf(os.Stderr, "Warning: the bundle has no user namespace, pce runs it in one mapping %s\n", formatIDMappings(specIDMappings(ids.UIDs)))
+	}
+	if err := o.Resources.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateMounts(o.Mounts); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(bundleRootfs(s, dir)); err != nil {
+		return nil, fmt.Errorf("invalid root filesystem: %v", err)
+	}
+	if _, err := bundleSeccompFilter(s); err != nil {
+		return nil, err
+	}
+
+	c, err := newContainer("", s.Process.Args, o)
+	if err != nil {
+		return nil, err
+	}
+	c.Spec = s
+	if err := c.save(); err != nil {
+		removeContainerDir(c)
+		return nil, fmt.Errorf("failed to save container: %v", err)
+	}
+	return c, nil
+}
+
+// bundleRootfs returns the root filesystem of the bundle in dir on the host.
+func bundleRootfs(s *spec.Spec, dir string) string {
+	if filepath.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return filepath.Join(dir, s.Root.Path)
+}
+
+// bundleCapabilities returns the bounding and ambient capabilities of the
+// process of a bundle, which were validated when the container was created.
+func bundleCapabilities(s *spec.Spec) (bounding, ambient []string) {
+	caps := s.Process.Capabilities
+	if caps == nil {
+		return nil, nil
+	}
+	for _, name := range caps.Bounding {
+		c, _ := parseCapability(name)
+		bounding = append(bounding, c)
+	}
+	for _, name := range caps.Ambient {
+		c, _ := parseCapability(name)
+		ambient = append(ambient, c)
+	}
+	return bounding, ambient
+}
+
+// bundleSeccompFilter compiles the seccomp profile of a bundle, none if it
+// has none.
+func bundleSeccompFilter(s *spec.Spec) ([]seccomp.Instruction, error) {
+	if s.Linux == nil || s.Linux.Seccomp == nil {
+		return nil, nil
+	}
+	bounding, _ := bundleCapabilities(s)
+	return seccomp.Compile(s.Linux.Seccomp, bounding)
+}
+
+// bundleProcessConfig takes the process of a container from its bundle.
+// Unlike with images the environment is used as it is.
+func bundleProcessConfig(s *spec.Spec) processConfig {
+	p := s.Process
+	bounding, ambient := bundleCapabilities(s)
+	filter, _ := bundleSeccompFilter(s)
+	return processConfig{
+		Env:                 p.Env,
+		WorkingDir:          p.Cwd,
+		User:                fmt.Sprintf("%d:%d", p.User.UID, p.User.GID),
+		AdditionalGroups:    p.User.AdditionalGids,
+		Rlimits:             p.Rlimits,
+		Capabilities:        bounding,
+		AmbientCapabilities: ambient,
+		NoNewPrivileges:     p.NoNewPrivileges,
+		Seccomp:             filter,
+	}
+}
+
+// applyBundle sets up the root filesystem of a container from the bundle
+// in dir instead of the layers of an image.
+func applyBundle(config *initConfig, s *spec.Spec, dir string) {
+	config.Layers = nil
+	config.Rootfs = bundleRootfs(s, dir)
+	config.Hostname = s.Hostname
+	config.MaskedPaths, config.ReadonlyPaths = nil, nil
+	if s.Linux != nil {
+		config.MaskedPaths, config.ReadonlyPaths = s.Linux.MaskedPaths, s.Linux.ReadonlyPaths
+	}
+}
+
+// setRlimits applies the resource limits of a bundle to the current process,
+// which the command inherits.
+func setRlimits(limits []spec.Rlimit) error {
+	for _, l := range limits {
+		// syscall.Setrlimit keeps the Go runtime from restoring its own
+		// RLIMIT_NOFILE on exec
+		rlimit := syscall.Rlimit{Cur: l.Soft, Max: l.Hard}
+		if err := syscall.Setrlimit(rlimitTypes[l.Type], &rlimit); err != nil {
+			return fmt.Errorf("failed to set %s: %v", l.Type, err)
+		}
+	}
+	return nil
+}
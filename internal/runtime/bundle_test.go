@@ -0,0 +1,280 @@
+package runtime
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	img "github.com/troppes/portable-container-engine/internal/image"
+	"github.com/troppes/portable-container-engine/internal/spec"
+)
+
+func TestGenerateSpec(t *testing.T) {
+	config := v1.Config{Env: []string{"PATH=/usr/bin", "LANG=C"}, WorkingDir: "/app"}
+	opts := RunOptions{
+		Network:  NetworkPrivate,
+		TimeNS:   NamespacePrivate,
+		Env:      []string{"LANG=de"},
+		CapAdd:   []string{"NET_ADMIN"},
+		ReadOnly: true,
+		Mounts: []Mount{
+			{Type: MountBind, Source: "/srv", Destination: "/data", ReadOnly: true},
+			{Type: MountBind, Source: "/opt", Destination: "/opt", NoSuid: true, NoDev: true, NoExec: true},
+			{Type: MountTmpfs, Destination: "/run", Options: "size=1m", NoExec: true},
+		},
+		Resources: Resources{Memory: 64 << 20, CPUs: 0.5, PidsLimit: 100},
+	}
+	user := &execUser{UID: 101, GID: 101, Groups: []uint32{5}}
+	ids := &img.IDMappings{
+		UIDs: []img.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		GIDs: []img.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+	}
+
+	s, err := generateSpec(config, []string{"nginx"}, opts, user, ids, nil)
+	if err != nil {
+		t.Fatalf("generateSpec() failed: %v", err)
+	}
+
+	p := s.Process
+	if want := []string{"PATH=/usr/bin", "LANG=de", "HOSTNAME=container"}; !reflect.DeepEqual(p.Env, want) {
+		t.Errorf("Env = %v, want %v", p.Env, want)
+	}
+	if p.Cwd != "/app" || !reflect.DeepEqual(p.Args, []string{"nginx"}) {
+		t.Errorf("Cwd = %q, Args = %v", p.Cwd, p.Args)
+	}
+	if want := (spec.User{UID: 101, GID: 101, AdditionalGids: []uint32{5}}); !reflect.DeepEqual(p.User, want) {
+		t.Errorf("User = %+v, want %+v", p.User, want)
+	}
+	if !slices.Contains(p.Capabilities.Bounding, "CAP_CHOWN") || !reflect.DeepEqual(p.Capabilities.Ambient, []string{"CAP_NET_ADMIN"}) {
+		t.Errorf("Capabilities = %+v", p.Capabilities)
+	}
+	if !p.NoNewPrivileges || s.Hostname != defaultHostname || !s.Root.Readonly {
+		t.Errorf("NoNewPrivileges = %v, Hostname = %q, Root = %+v", p.NoNewPrivileges, s.Hostname, s.Root)
+	}
+	for _, typ := range []string{spec.UserNamespace, spec.NetworkNamespace, spec.IPCNamespace, spec.TimeNamespace} {
+		if !s.HasNamespace(typ) {
+			t.Errorf("spec does not have a %s namespace", typ)
+		}
+	}
+
+	wantUIDs := []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+	if !reflect.DeepEqual(s.Linux.UIDMappings, wantUIDs) {
+		t.Errorf("UIDMappings = %+v, want %+v", s.Linux.UIDMappings, wantUIDs)
+	}
+	if want := []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}; !reflect.DeepEqual(s.Linux.GIDMappings, want) {
+		t.Errorf("GIDMappings = %+v, want %+v", s.Linux.GIDMappings, want)
+	}
+
+	// Reading the spec back gives the options it was generated from
+	if err := checkBundleIDMappings(s, ids); err != nil {
+		t.Errorf("checkBundleIDMappings() error = %v", err)
+	}
+	got, err := bundleOptions(s, "/bundle", RunOptions{Name: "web"})
+	if err != nil {
+		t.Fatalf("bundleOptions() failed: %v", err)
+	}
+	if got.Name != "web" || got.Bundle != "/bundle" {
+		t.Errorf("Name = %q, Bundle = %q", got.Name, got.Bundle)
+	}
+	if got.Network != opts.Network || got.IPC != NamespacePrivate || got.CgroupNS != NamespacePrivate || got.TimeNS != opts.TimeNS {
+		t.Errorf("namespaces = %s %s %s %s", got.Network, got.IPC, got.CgroupNS, got.TimeNS)
+	}
+	if !reflect.DeepEqual(got.Mounts, opts.Mounts) {
+		t.Errorf("Mounts = %+v, want %+v", got.Mounts, opts.Mounts)
+	}
+	if got.Resources != opts.Resources {
+		t.Errorf("Resources = %+v, want %+v", got.Resources, opts.Resources)
+	}
+	if !got.ReadOnly || got.NewPrivileges {
+		t.Errorf("ReadOnly = %v, NewPrivileges = %v", got.ReadOnly, got.NewPrivileges)
+	}
+}
+
+func TestDefaultProtectedPaths(t *testing.T) {
+	// The defaults of the OCI runtime spec, which pce masks or makes
+	// read-only at least, and the paths Docker masks on top
+	tests := []struct {
+		path     string
+		masked   bool
+		readonly bool
+	}{
+		{path: "/proc/acpi", masked: true},
+		{path: "/proc/asound", masked: true},
+		{path: "/proc/kcore", masked: true},
+		{path: "/proc/keys", masked: true},
+		{path: "/proc/latency_stats", masked: true},
+		{path: "/proc/timer_list", masked: true},
+		{path: "/proc/timer_stats", masked: true},
+		{path: "/proc/sched_debug", masked: true},
+		{path: "/proc/scsi", masked: true},
+		{path: "/sys/firmware", masked: true},
+		{path: "/proc/interrupts", masked: true},
+		{path: "/sys/devices/virtual/powercap", masked: true},
+		{path: "/proc/bus", readonly: true},
+		{path: "/proc/fs", readonly: true},
+		{path: "/proc/irq", readonly: true},
+		{path: "/proc/sys", readonly: true},
+		{path: "/proc/sysrq-trigger", readonly: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := slices.Contains(defaultMaskedPaths, tt.path); got != tt.masked {
+				t.Errorf("masked = %v, want %v", got, tt.masked)
+			}
+			if got := slices.Contains(defaultReadonlyPaths, tt.path); got != tt.readonly {
+				t.Errorf("read-only = %v, want %v", got, tt.readonly)
+			}
+		})
+	}
+	if n := len(defaultMaskedPaths) + len(defaultReadonlyPaths); n != len(tests) {
+		t.Errorf("%d protected paths, want %d", n, len(tests))
+	}
+}
+
+func TestBundleOptionsInvalid(t *testing.T) {
+	valid := func() *spec.Spec {
+		return &spec.Spec{
+			Version: spec.Version,
+			Process: &spec.Process{Args: []string{"sh"}, Cwd: "/"},
+			Root:    &spec.Root{Path: "rootfs"},
+			Linux: &spec.Linux{Namespaces: []spec.Namespace{
+				{Type: spec.PIDNamespace}, {Type: spec.MountNamespace}, {Type: spec.UTSNamespace},
+			}},
+		}
+	}
+	if _, err := bundleOptions(valid(), "/bundle", RunOptions{}); err != nil {
+		t.Fatalf("bundleOptions() of a valid spec failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *spec.Spec)
+	}{
+		{"shared pid namespace", func(s *spec.Spec) { s.Linux.Namespaces = s.Linux.Namespaces[1:] }},
+		{"no linux", func(s *spec.Spec) { s.Linux = nil }},
+		{"namespace path", func(s *spec.Spec) { s.Linux.Namespaces[0].Path = "/proc/1/ns/pid" }},
+		{"unknown namespace", func(s *spec.Spec) {
+			s.Linux.Namespaces = append(s.Linux.Namespaces, spec.Namespace{Type: "foo"})
+		}},
+		{"unknown rlimit", func(s *spec.Spec) { s.Process.Rlimits = []spec.Rlimit{{Type: "RLIMIT_FOO"}} }},
+		{"unknown capability", func(s *spec.Spec) {
+			s.Process.Capabilities = &spec.Capabilities{Bounding: []string{"CAP_FOO"}}
+		}},
+		{"all capabilities", func(s *spec.Spec) {
+			s.Process.Capabilities = &spec.Capabilities{Bounding: []string{"ALL"}}
+		}},
+		{"unsupported mount", func(s *spec.Spec) {
+			s.Mounts = []spec.Mount{{Destination: "/mnt", Type: "nfs", Source: "server:/export"}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.modify(s)
+			if _, err := bundleOptions(s, "/bundle", RunOptions{}); err == nil {
+				t.Error("bundleOptions() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckBundleIDMappings(t *testing.T) {
+	ids := &img.IDMappings{
+		UIDs: []img.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		GIDs: []img.IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+	}
+	uids := []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+	gids := []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}
+
+	tests := []struct {
+		name    string
+		linux   *spec.Linux
+		wantErr bool
+	}{
+		{name: "no linux section"},
+		{name: "no mappings", linux: &spec.Linux{}},
+		{name: "same mappings", linux: &spec.Linux{UIDMappings: uids, GIDMappings: gids}},
+		{
+			name:    "other uid mappings",
+			linux:   &spec.Linux{UIDMappings: []spec.IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}}, GIDMappings: gids},
+			wantErr: true,
+		},
+		{
+			name:    "other gid mappings",
+			linux:   &spec.Linux{UIDMappings: uids, GIDMappings: []spec.IDMapping{{ContainerID: 0, HostID: 0, Size: 1}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBundleIDMappings(&spec.Spec{Linux: tt.linux}, ids)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkBundleIDMappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBundleMount(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount spec.Mount
+		want  Mount
+		skip  bool
+	}{
+		{
+			name:  "bind",
+			mount: spec.Mount{Destination: "/data", Type: "bind", Source: "/srv", Options: []string{"rbind", "ro"}},
+			want:  Mount{Type: MountBind, Source: "/srv", Destination: "/data", ReadOnly: true},
+		},
+		{
+			name:  "bind flags",
+			mount: spec.Mount{Destination: "/data", Type: "bind", Source: "/srv", Options: []string{"rbind", "nosuid", "nodev", "noexec"}},
+			want:  Mount{Type: MountBind, Source: "/srv", Destination: "/data", NoSuid: true, NoDev: true, NoExec: true},
+		},
+		{
+			name:  "bind by option",
+			mount: spec.Mount{Destination: "/data", Type: "none", Source: "/srv", Options: []string{"bind", "rprivate"}},
+			want:  Mount{Type: MountBind, Source: "/srv", Destination: "/data"},
+		},
+		{
+			name:  "relative source",
+			mount: spec.Mount{Destination: "/data/", Type: "bind", Source: "data"},
+			want:  Mount{Type: MountBind, Source: "/bundle/data", Destination: "/data"},
+		},
+		{
+			name:  "tmpfs",
+			mount: spec.Mount{Destination: "/run", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "noexec", "size=1m", "mode=755"}},
+			want:  Mount{Type: MountTmpfs, Destination: "/run", Options: "size=1m,mode=755", NoExec: true},
+		},
+		{
+			name:  "cgroup",
+			mount: spec.Mount{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup"},
+			skip:  true,
+		},
+		{
+			name:  "system mount",
+			mount: spec.Mount{Destination: "/dev/shm", Type: "tmpfs", Source: "shm"},
+			skip:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := bundleMount(tt.mount, "/bundle")
+			if err != nil {
+				t.Fatalf("bundleMount() failed: %v", err)
+			}
+			if ok == tt.skip {
+				t.Fatalf("bundleMount() ok = %v, want %v", ok, !tt.skip)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bundleMount() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
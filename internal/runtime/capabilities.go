@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// capabilityNames are the Linux capabilities, indexed by their number.
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// defaultCapabilities are the capabilities a container keeps by default,
+// the same as with Docker.
+var defaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// allCapabilitiesName stands for every capability in --cap-add and
+// --cap-drop.
+const allCapabilitiesName = "ALL"
+
+// parseCapability returns the canonical name of a capability given in any
+// case, with or without the CAP_ prefix, like net_admin for CAP_NET_ADMIN.
+func parseCapability(name string) (string, error) {
+	upper := strings.ToUpper(name)
+	if upper == allCapabilitiesName {
+		return upper, nil
+	}
+	if !strings.HasPrefix(upper, "CAP_") {
+		upper = "CAP_" + upper
+	}
+	if capabilityNumber(upper) < 0 {
+		return "", fmt.Errorf("unknown capability %q", name)
+	}
+	return upper, nil
+}
+
+// capabilityNumber returns the number of a canonical capability name, or -1.
+func capabilityNumber(name string) int {
+	for i, c := range capabilityNames {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCapabilities parses a list of capabilities into a set.
+func parseCapabilities(names []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		c, err := parseCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		set[c] = true
+	}
+	return set, nil
+}
+
+// capabilities returns the capabilities of the container, ordered by their
+// number. Like Docker it starts from every capability if CapAdd holds ALL,
+// from none if CapDrop holds ALL and from the defaults otherwise, where
+// CapAdd takes precedence over CapDrop.
+func (o RunOptions) capabilities() ([]string, error) {
+	add, err := parseCapabilities(o.CapAdd)
+	if err != nil {
+		return nil, err
+	}
+	drop, err := parseCapabilities(o.CapDrop)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	switch {
+	case add[allCapabilitiesName]:
+		for _, c := range capabilityNames {
+			set[c] = !drop[c]
+		}
+	case drop[allCapabilitiesName]:
+		set = add
+	default:
+		for _, c := range defaultCapabilities {
+			set[c] = !drop[c]
+		}
+		for c := range add {
+			set[c] = true
+		}
+	}
+
+	var caps []string
+	for _, c := range capabilityNames {
+		if set[c] {
+			caps = append(caps, c)
+		}
+	}
+	return caps, nil
+}
+
+// ambientCapabilities returns the capabilities added with CapAdd. Processes
+// of users other than root lose the capabilities of the container when
+// switching users and keep only these, as ambient capabilities.
+func (o RunOptions) ambientCapabilities() ([]string, error) {
+	add, err := parseCapabilities(o.CapAdd)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := o.capabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	var ambient []string
+	for _, c := range caps {
+		if add[allCapabilitiesName] || add[c] {
+			ambient = append(ambient, c)
+		}
+	}
+	return ambient, nil
+}
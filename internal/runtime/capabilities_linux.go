@@ -0,0 +1,122 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capabilities and no_new_privs belong to a thread, not to the process. The
+// functions below change the current thread, which must stay locked until
+// the command of the container is executed or started from it.
+//
+// execve recomputes the capabilities of the command: root gets its bounding
+// set, other users only their ambient capabilities. Until then the thread
+// keeps its own, which it needs to switch users.
+
+// capabilityMask returns the capabilities as a bit mask of their numbers,
+// leaving out those the kernel does not know.
+func capabilityMask(names []string) uint64 {
+	last := len(allCapabilities()) - 1
+	var mask uint64
+	for _, name := range names {
+		if c := capabilityNumber(name); c >= 0 && c <= last {
+			mask |= 1 << c
+		}
+	}
+	return mask
+}
+
+// capabilitiesOf returns the numbers of the capabilities in mask, the way
+// SysProcAttr.AmbientCaps takes them.
+func capabilitiesOf(mask uint64) []uintptr {
+	var caps []uintptr
+	for c := range 64 {
+		if mask&(1<<c) != 0 {
+			caps = append(caps, uintptr(c))
+		}
+	}
+	return caps
+}
+
+// limitCapabilities drops every capability but caps from the bounding set of
+// the current thread, so processes executed from it cannot gain the others,
+// and clears its inheritable and ambient capabilities.
+func limitCapabilities(caps []string) error {
+	mask := capabilityMask(caps)
+	for _, c := range allCapabilities() {
+		if mask&(1<<c) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, c, 0, 0, 0); err != nil {
+			return fmt.Errorf("failed to drop %s from the bounding set: %v", capabilityNames[c], err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %v", err)
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to get capabilities: %v", err)
+	}
+	data[0].Inheritable, data[1].Inheritable = 0, 0
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to clear inheritable capabilities: %v", err)
+	}
+	return nil
+}
+
+// setCapabilities sets the effective and permitted capabilities of the
+// current thread to permitted and its inheritable ones to inheritable.
+func setCapabilities(permitted, inheritable uint64) error {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{
+		{Effective: uint32(permitted), Permitted: uint32(permitted), Inheritable: uint32(inheritable)},
+		{Effective: uint32(permitted >> 32), Permitted: uint32(permitted >> 32), Inheritable: uint32(inheritable >> 32)},
+	}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to set capabilities: %v", err)
+	}
+	return nil
+}
+
+// switchUser switches the current thread to cred. Switching away from root
+// clears the capabilities, the ambient ones are kept and raised again, so
+// the executed command gets them.
+func switchUser(cred *syscall.Credential, ambient []string) error {
+	mask := capabilityMask(ambient)
+	if cred == nil || mask == 0 {
+		return setCredential(cred)
+	}
+
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to keep capabilities: %v", err)
+	}
+	if err := setCredential(cred); err != nil {
+		return err
+	}
+	// Only capabilities that are permitted and inheritable can be ambient
+	if err := setCapabilities(mask, mask); err != nil {
+		return err
+	}
+	for _, c := range capabilitiesOf(mask) {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, c, 0, 0); err != nil {
+			return fmt.Errorf("failed to raise ambient %s: %v", capabilityNames[c], err)
+		}
+	}
+	return nil
+}
+
+// setNoNewPrivileges keeps the current thread and the processes it starts
+// from gaining privileges through setuid binaries and file capabilities.
+func setNoNewPrivileges() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCapability(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"CAP_NET_ADMIN", "CAP_NET_ADMIN", false},
+		{"net_admin", "CAP_NET_ADMIN", false},
+		{"Sys_Ptrace", "CAP_SYS_PTRACE", false},
+		{"all", "ALL", false},
+		{"NET_WIZARD", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCapability(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCapability() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCapability() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name        string
+		add, drop   []string
+		want        []string
+		wantAmbient []string
+		wantErr     bool
+	}{
+		{
+			name: "defaults",
+			want: defaultCapabilities,
+		},
+		{
+			name:        "add and drop",
+			add:         []string{"NET_ADMIN"},
+			drop:        []string{"chown", "CAP_KILL"},
+			want:        []string{"CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP", "CAP_NET_BIND_SERVICE", "CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_SYS_CHROOT", "CAP_MKNOD", "CAP_AUDIT_WRITE", "CAP_SETFCAP"},
+			wantAmbient: []string{"CAP_NET_ADMIN"},
+		},
+		{
+			name:        "add wins over drop",
+			add:         []string{"KILL"},
+			drop:        []string{"KILL"},
+			want:        defaultCapabilities,
+			wantAmbient: []string{"CAP_KILL"},
+		},
+		{
+			name:        "drop all",
+			add:         []string{"NET_BIND_SERVICE"},
+			drop:        []string{"ALL"},
+			want:        []string{"CAP_NET_BIND_SERVICE"},
+			wantAmbient: []string{"CAP_NET_BIND_SERVICE"},
+		},
+		{
+			name: "drop all without add",
+			drop: []string{"all"},
+		},
+		{
+			name:    "unknown capability",
+			add:     []string{"NET_WIZARD"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := RunOptions{CapAdd: tt.add, CapDrop: tt.drop}
+			got, err := opts.capabilities()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("capabilities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !sameCapabilities(got, tt.want) {
+				t.Errorf("capabilities() = %v, want %v", got, tt.want)
+			}
+			ambient, err := opts.ambientCapabilities()
+			if err != nil {
+				t.Fatalf("ambientCapabilities() error = %v", err)
+			}
+			if !sameCapabilities(ambient, tt.wantAmbient) {
+				t.Errorf("ambientCapabilities() = %v, want %v", ambient, tt.wantAmbient)
+			}
+		})
+	}
+}
+
+func TestAddAllCapabilities(t *testing.T) {
+	opts := RunOptions{CapAdd: []string{"ALL"}, CapDrop: []string{"SYS_ADMIN"}}
+	got, err := opts.capabilities()
+	if err != nil {
+		t.Fatalf("capabilities() error = %v", err)
+	}
+	if len(got) != len(capabilityNames)-1 {
+		t.Errorf("capabilities() returned %d capabilities, want %d", len(got), len(capabilityNames)-1)
+	}
+	for _, c := range got {
+		if c == "CAP_SYS_ADMIN" {
+			t.Errorf("capabilities() kept dropped CAP_SYS_ADMIN")
+		}
+	}
+}
+
+func TestCapabilityNames(t *testing.T) {
+	for i, c := range capabilityNames {
+		if !strings.HasPrefix(c, "CAP_") {
+			t.Errorf("capability %d has no CAP_ prefix: %s", i, c)
+		}
+		if capabilityNumber(c) != i {
+			t.Errorf("capability %s is listed twice", c)
+		}
+	}
+}
+
+// sameCapabilities compares two lists of capabilities regardless of order.
+func sameCapabilities(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, c := range a {
+		seen[c] = true
+	}
+	for _, c := range b {
+		if !seen[c] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+//go:build linux
+
+package runtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupParentName is the cgroup all container cgroups are created in.
+const cgroupParentName = "pce"
+
+// cgroupLeafName is the cgroup the processes of the cgroup pce runs in are
+// moved to when the container cgroups are created below it.
+const cgroupLeafName = "pce-init"
+
+// createCgroup creates a cgroup v2 for the container with the given limits.
+// It is placed below the highest cgroup pce may write to, which is the root
+// of the hierarchy for root and the delegated user@<uid>.service of a systemd
+// user session otherwise.
+func createCgroup(id string, res Resources) (string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+	base, err := delegatedCgroup(mount)
+	if err != nil {
+		return "", err
+	}
+
+	parent := filepath.Join(base, cgroupParentName)
+	if err := os.Mkdir(parent, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create cgroup %s: %v", parent, err)
+	}
+
+	controllers := res.controllers()
+	if err := enableBaseControllers(base, controllers); err != nil {
+		return "", err
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(parent, id)
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create cgroup %s: %v", dir, err)
+	}
+
+	for file, value := range res.cgroupFiles() {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			removeCgroup(dir)
+			return "", fmt.Errorf("failed to set %s: %v", file, err)
+		}
+	}
+
+	return dir, nil
+}
+
+// removeCgroup deletes the cgroup of a container once all of its processes
+// are gone.
+func removeCgroup(dir string) error {
+	var err error
+	// The processes of the container may still be exiting
+	for i := 0; i < 20; i++ {
+		err = os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %v", dir, err)
+}
+
+// cgroup2Mount returns where the cgroup v2 hierarchy is mounted, which is
+// /sys/fs/cgroup/unified on systems that still use cgroup v1 as well.
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The filesystem type follows the separator after the optional fields
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("resource limits need cgroup v2, but it is not mounted")
+}
+
+// delegatedCgroup walks up from the cgroup of pce and returns the highest
+// cgroup directory it may create cgroups in and move processes into.
+func delegatedCgroup(mount string) (string, error) {
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+
+	base := ""
+	for path := own; ; path = filepath.Dir(path) {
+		dir := filepath.Join(mount, path)
+		if unix.Access(dir, unix.W_OK) != nil || unix.Access(filepath.Join(dir, "cgroup.procs"), unix.W_OK) != nil {
+			break
+		}
+		base = dir
+		if path == "/" {
+			break
+		}
+	}
+
+	if base == "" {
+		return "", fmt.Errorf("no writable cgroup v2 found for resource limits, run pce as root or in a systemd user session with cgroup delegation, e.g. with systemd-run --user --scope -p Delegate=yes")
+	}
+	return base, nil
+}
+
+// ownCgroup returns the cgroup v2 path of the current process.
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("process is not in a cgroup v2")
+}
+
+// enableBaseControllers enables the controllers in the cgroup the containers
+// are created below. cgroup v2 does not pass controllers on from cgroups with
+// processes of their own, so if pce runs in it, like in a systemd scope, its
+// processes are moved to a leaf below it first, like podman does.
+func enableBaseControllers(base string, controllers []string) error {
+	err := enableControllers(base, controllers)
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+
+	own, ownErr := ownCgroup()
+	if ownErr == nil {
+		if mount, mountErr := cgroup2Mount(); mountErr == nil && filepath.Join(mount, own) == base {
+			if err = leaveCgroup(base); err == nil {
+				err = enableControllers(base, controllers)
+			}
+		}
+	}
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("cannot enable controllers in cgroup %s, which has processes of its own, run pce in a cgroup of its own, e.g. with systemd-run --user --scope -p Delegate=yes", base)
+	}
+	return err
+}
+
+// leaveCgroup moves the processes of dir, pce among them, into a leaf cgroup
+// below it.
+func leaveCgroup(dir string) error {
+	leaf := filepath.Join(dir, cgroupLeafName)
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create cgroup %s: %v", leaf, err)
+	}
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644)
+		// Processes may exit in the meantime
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed to move process %s to cgroup %s: %v", pid, leaf, err)
+		}
+	}
+	return nil
+}
+
+// enableControllers makes the controllers available to the children of dir.
+func enableControllers(dir string, controllers []string) error {
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	enabled, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+
+	for _, controller := range controllers {
+		if slices.Contains(strings.Fields(string(enabled)), controller) {
+			continue
+		}
+		if !slices.Contains(strings.Fields(string(available)), controller) {
+			return fmt.Errorf("the %s controller is not available in %s, it is not delegated or still used by cgroup v1", controller, dir)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			return fmt.Errorf("failed to enable the %s controller in %s: %w", controller, dir, err)
+		}
+	}
+	return nil
+}
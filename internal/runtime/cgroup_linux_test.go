@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLeaveCgroup(t *testing.T) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		t.Skipf("cgroup v2 not available: %v", err)
+	}
+	dir, err := os.MkdirTemp(mount, "pce-test-")
+	if err != nil {
+		t.Skipf("cannot create a cgroup: %v", err)
+	}
+	defer removeCgroup(dir)
+	defer removeCgroup(filepath.Join(dir, cgroupLeafName))
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := strconv.Itoa(cmd.Process.Pid)
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(pid), 0644); err != nil {
+		t.Skipf("cannot move processes between cgroups: %v", err)
+	}
+
+	if err := leaveCgroup(dir); err != nil {
+		t.Fatalf("leaveCgroup() error = %v", err)
+	}
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		t.Fatalf("failed to read processes: %v", err)
+	}
+	if len(strings.TrimSpace(string(procs))) > 0 {
+		t.Errorf("cgroup still has processes %q", procs)
+	}
+	cgroup, err := os.ReadFile("/proc/" + pid + "/cgroup")
+	if err != nil {
+		t.Fatalf("failed to read cgroup of process: %v", err)
+	}
+	want := "0::/" + filepath.Base(dir) + "/" + cgroupLeafName
+	if !strings.Contains(string(cgroup), want) {
+		t.Errorf("process is in cgroup %q, want %s", cgroup, want)
+	}
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// consoleSocketFd is the file descriptor the init process of a container
+// with a TTY sends the master of its pseudo-terminal over.
+const consoleSocketFd = 4
+
+// setupConsole allocates a pseudo-terminal in the root filesystem of the
+// container, which must have been entered already, and makes it the
+// controlling terminal and standard streams of the current process. The
+// terminal comes from the devpts instance of the container, so it has a name
+// there, and is handed to the user of the process. The master is sent to pce
+// over the console socket.
+func setupConsole(spec string, size *consoleSize) error {
+	socket := os.NewFile(consoleSocketFd, "console-socket")
+	defer socket.Close()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open /dev/ptmx: %v", err)
+	}
+	defer master.Close()
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		return fmt.Errorf("failed to unlock terminal: %v", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		return fmt.Errorf("failed to get terminal number: %v", err)
+	}
+	if size != nil {
+		ws := &unix.Winsize{Row: size.Height, Col: size.Width}
+		if err := unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws); err != nil {
+			return fmt.Errorf("failed to set terminal size: %v", err)
+		}
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer slave.Close()
+	// Users that are not mapped keep the terminal owned by root
+	if u, err := lookupUser(spec); err == nil {
+		os.Chown(name, int(u.UID), int(u.GID))
+	}
+
+	if err := unix.Sendmsg(int(socket.Fd()), []byte{0}, unix.UnixRights(int(master.Fd())), nil, 0); err != nil {
+		return fmt.Errorf("failed to send terminal: %v", err)
+	}
+
+	if _, err := unix.Setsid(); err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	if err := unix.IoctlSetInt(int(slave.Fd()), unix.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("failed to set controlling terminal: %v", err)
+	}
+	for fd := 0; fd < 3; fd++ {
+		if err := unix.Dup3(int(slave.Fd()), fd, 0); err != nil {
+			return fmt.Errorf("failed to attach terminal: %v", err)
+		}
+	}
+	return nil
+}
+
+// receiveConsole receives the master of the pseudo-terminal from the init
+// process of a container.
+func receiveConsole(socket *os.File) (*os.File, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), buf, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive terminal: %v", err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("container exited before allocating its terminal")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("container sent an invalid terminal")
+	}
+	return os.NewFile(uintptr(fds[0]), "console"), nil
+}
+
+// attachConsole copies stdin to the terminal of the container and its output
+// to stdout. The output is complete once the returned channel is closed,
+// which happens when every process of the container closed the terminal.
+func attachConsole(console *os.File, stdin io.Reader, stdout io.Writer) <-chan struct{} {
+	if stdin != nil {
+		go io.Copy(console, stdin)
+	}
+	output := make(chan struct{})
+	go func() {
+		// Reading fails with EIO once the other side is closed
+		io.Copy(stdout, console)
+		close(output)
+	}()
+	return output
+}
+
+// resizeConsole gives the terminal of the container the size of the host's
+// terminal and keeps it in sync until done is closed.
+func resizeConsole(console, host *os.File, done <-chan struct{}) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	for {
+		if ws, err := unix.IoctlGetWinsize(int(host.Fd()), unix.TIOCGWINSZ); err == nil {
+			unix.IoctlSetWinsize(int(console.Fd()), unix.TIOCSWINSZ, ws)
+		}
+		select {
+		case <-winch:
+		case <-done:
+			return
+		}
+	}
+}
@@ -1,8 +1,150 @@
 package runtime
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+)
+
 type ContainerRuntime interface {
-	Run(image string, command []string) error
+	// Run creates a container, runs it in the foreground and removes it
+	// again once it exited.
+	Run(image string, command []string, opts RunOptions) error
+	Create(image string, command []string, opts RunOptions) (*Container, error)
+	// Spec writes an OCI bundle for a container of the image to
+	// opts.Bundle, which Create and Run accept in place of the image.
+	Spec(image string, command []string, opts RunOptions) error
+	// Start runs a created container, in the background under a supervisor
+	// process when detach is set.
+	Start(container string, detach bool) error
+	List() ([]*Container, error)
+	Remove(container string, force bool) error
+	// Stop sends the stop signal to a running container and kills it once
+	// timeout seconds passed, or its stop timeout if timeout is nil.
+	Stop(container string, timeout *int) error
+	// Kill sends a signal to a running container, SIGKILL if it is empty.
+	Kill(container string, signal string) error
+	// Exec runs an additional command inside a running container.
+	Exec(container string, command []string) error
+	Logs(container string, opts LogOptions) error
+	// Supervise runs a detached container and logs its output.
+	Supervise(container string) error
 	CreateChildProcess(path string, command []string) error
+	// ExecChildProcess runs the command of Exec once inside the container.
+	ExecChildProcess(path string, command []string) error
+	// ServePorts opens connections to published ports from inside the
+	// network namespace of a container.
+	ServePorts() error
+	// UnpackLayer unpacks the layer tarball on stdin into dir from inside
+	// the user namespace of containers, where its owners can be assigned.
+	UnpackLayer(dir string) error
+	// CopyLayers copies unpacked layers onto dest from inside the user
+	// namespace of containers.
+	CopyLayers(dest string, layers []string) error
+}
+
+// Network modes of a container
+const (
+	// NetworkHost shares the network of the host.
+	NetworkHost = "host"
+	// NetworkPrivate gives the container its own network namespace.
+	NetworkPrivate = "private"
+)
+
+// Modes of the IPC, cgroup and time namespaces of a container
+const (
+	// NamespaceHost shares the namespace of the host.
+	NamespaceHost = "host"
+	// NamespacePrivate gives the container its own namespace.
+	NamespacePrivate = "private"
+)
+
+// RunOptions holds the settings of a container given on the command line.
+type RunOptions struct {
+	Name    string        `json:"name,omitempty"`
+	Network string        `json:"network,omitempty"`
+	Ports   []PortMapping `json:"ports,omitempty"`
+	// IPC and CgroupNS default to private, TimeNS to host.
+	IPC      string `json:"ipc,omitempty"`
+	CgroupNS string `json:"cgroupns,omitempty"`
+	TimeNS   string `json:"timens,omitempty"`
+	// Env holds KEY=VALUE variables set on top of the image's environment.
+	Env []string `json:"env,omitempty"`
+	// Mounts are bind mounts, volumes and tmpfs mounts added to the rootfs.
+	Mounts []Mount `json:"mounts,omitempty"`
+
+	Resources Resources `json:"resources,omitzero"`
+
+	// StopSignal is sent to stop the container instead of the StopSignal
+	// of the image or SIGTERM.
+	StopSignal string `json:"stopSignal,omitempty"`
+	// StopTimeout is how many seconds a stopped container has to exit
+	// before it is killed.
+	StopTimeout *int `json:"stopTimeout,omitempty"`
+
+	// CapAdd and CapDrop adjust the default capabilities of the container,
+	// with names like NET_ADMIN or ALL for every capability.
+	CapAdd  []string `json:"capAdd,omitempty"`
+	CapDrop []string `json:"capDrop,omitempty"`
+	// NewPrivileges lets processes gain privileges through setuid binaries
+	// and file capabilities, which no_new_privs prevents by default.
+	NewPrivileges bool `json:"newPrivileges,omitempty"`
+	// Seccomp replaces the default seccomp profile of the container,
+	// SeccompUnconfined runs it without a seccomp filter.
+	Seccomp           *seccomp.Profile `json:"seccomp,omitempty"`
+	SeccompUnconfined bool             `json:"seccompUnconfined,omitempty"`
+
+	// TTY runs the command on a pseudo-terminal of the container instead
+	// of passing the standard streams through.
+	TTY bool `json:"tty,omitempty"`
+
+	// Init runs a minimal init as PID 1, which forwards signals to the
+	// command and reaps orphaned processes.
+	Init bool `json:"init,omitempty"`
+
+	// NoPivot enters the rootfs with chroot instead of pivot_root.
+	NoPivot bool `json:"noPivot,omitempty"`
+	// ReadOnly mounts the root filesystem of the container read-only.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Bundle is the directory of an OCI bundle the container runs instead
+	// of an image, or that `pce spec` writes.
+	Bundle string `json:"bundle,omitempty"`
+}
+
+// validateNamespaces checks the modes of the IPC, cgroup and time namespaces.
+func (o RunOptions) validateNamespaces() error {
+	for flag, mode := range map[string]string{"ipc": o.IPC, "cgroupns": o.CgroupNS, "timens": o.TimeNS} {
+		switch mode {
+		case "", NamespaceHost, NamespacePrivate:
+		default:
+			return fmt.Errorf("unknown %s mode %q, use %s or %s", flag, mode, NamespaceHost, NamespacePrivate)
+		}
+	}
+	return nil
+}
+
+func (o RunOptions) privateIPC() bool {
+	return o.IPC != NamespaceHost
+}
+
+func (o RunOptions) privateCgroupNS() bool {
+	return o.CgroupNS != NamespaceHost
+}
+
+func (o RunOptions) privateTimeNS() bool {
+	return o.TimeNS == NamespacePrivate
+}
+
+// defaultStopTimeout is how long a container has to exit after its stop
+// signal unless configured otherwise.
+const defaultStopTimeout = 5 * time.Second
+
+func (o RunOptions) stopTimeout() time.Duration {
+	if o.StopTimeout == nil {
+		return defaultStopTimeout
+	}
+	return time.Duration(*o.StopTimeout) * time.Second
 }
 
 func GetRuntime() ContainerRuntime {
@@ -9,10 +9,66 @@ import (
 
 type platformRuntime struct{}
 
-func (r *platformRuntime) Run(image string, command []string) error {
+func (r *platformRuntime) Run(image string, command []string, opts RunOptions) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Create(image string, command []string, opts RunOptions) (*Container, error) {
+	return nil, fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Spec(image string, command []string, opts RunOptions) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Start(container string, detach bool) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) List() ([]*Container, error) {
+	return nil, fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Remove(container string, force bool) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Stop(container string, timeout *int) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Kill(container string, signal string) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Exec(container string, command []string) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) ExecChildProcess(path string, command []string) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Logs(container string, opts LogOptions) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) Supervise(container string) error {
 	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
 }
 
 func (r *platformRuntime) CreateChildProcess(path string, command []string) error {
 	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
 }
+
+func (r *platformRuntime) ServePorts() error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) UnpackLayer(dir string) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
+
+func (r *platformRuntime) CopyLayers(dest string, layers []string) error {
+	return fmt.Errorf("container functionality is not supported on %s. Please use Linux", runtime.GOOS)
+}
@@ -3,136 +3,1091 @@
 package runtime
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
-	"strings"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"syscall"
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	img "github.com/troppes/portable-container-engine/internal/image"
-	util "github.com/troppes/portable-container-engine/internal/util"
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+	"github.com/troppes/portable-container-engine/internal/spec"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
 type platformRuntime struct {
 }
 
-func (r *platformRuntime) Run(image string, command []string) error {
-	// Create temporary directory for this container run
-	tempDir, err := os.MkdirTemp("", "container-runtime-")
+// initConfig is passed from Run to the re-executed child through a pipe and
+// holds everything the child needs to set up the container.
+type initConfig struct {
+	Layers []string `json:"layers"`
+	// Rootfs is the root filesystem of a bundle, used instead of the layers
+	Rootfs     string  `json:"rootfs,omitempty"`
+	UpperDir   string  `json:"upperDir"`
+	WorkDir    string  `json:"workDir"`
+	Network    string  `json:"network"`
+	Nameserver string  `json:"nameserver,omitempty"`
+	NoPivot    bool    `json:"noPivot,omitempty"`
+	ReadOnly   bool    `json:"readOnly,omitempty"`
+	Hostname   string  `json:"hostname"`
+	TimeNS     bool    `json:"timens,omitempty"`
+	Init       bool    `json:"init,omitempty"`
+	Mounts     []Mount `json:"mounts,omitempty"`
+	// MaskedPaths are hidden from the container, ReadonlyPaths read-only
+	MaskedPaths   []string `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string `json:"readonlyPaths,omitempty"`
+
+	Process processConfig `json:"process"`
+}
+
+// processConfig describes how the command of a container is executed, both
+// the init process and commands run with `pce exec`.
+type processConfig struct {
+	Env        []string `json:"env"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	// AdditionalGroups are added to the supplementary groups of the user
+	AdditionalGroups []uint32 `json:"additionalGroups,omitempty"`
+	Terminal         bool     `json:"terminal,omitempty"`
+	// Rlimits are the resource limits of a bundle
+	Rlimits []spec.Rlimit `json:"rlimits,omitempty"`
+	// Capabilities limit the bounding set, AmbientCapabilities are kept by
+	// users other than root
+	Capabilities        []string `json:"capabilities"`
+	AmbientCapabilities []string `json:"ambientCapabilities,omitempty"`
+	NoNewPrivileges     bool     `json:"noNewPrivileges,omitempty"`
+	// Seccomp is the seccomp filter of the command, none if empty
+	Seccomp []seccomp.Instruction `json:"seccomp,omitempty"`
+	// ConsoleSize is the initial size of the terminal, if pce runs on one
+	ConsoleSize *consoleSize `json:"consoleSize,omitempty"`
+}
+
+type consoleSize struct {
+	Height uint16 `json:"height"`
+	Width  uint16 `json:"width"`
+}
+
+// newProcessConfig applies the options of the container on top of the
+// config of its image, or takes the process of its bundle.
+func newProcessConfig(c *Container) processConfig {
+	if c.Spec != nil {
+		return bundleProcessConfig(c.Spec)
+	}
+
+	// The capabilities and the seccomp profile were validated when the
+	// container was created
+	caps, _ := c.Options.capabilities()
+	ambient, _ := c.Options.ambientCapabilities()
+	filter, _ := c.Options.seccompFilter(caps)
+	return processConfig{
+		Env:                 containerEnv(c.ImageConfig.Env, c.Options),
+		WorkingDir:          c.ImageConfig.WorkingDir,
+		User:                c.ImageConfig.User,
+		Capabilities:        caps,
+		AmbientCapabilities: ambient,
+		NoNewPrivileges:     !c.Options.NewPrivileges,
+		Seccomp:             filter,
+	}
+}
+
+// seccompFilter compiles the seccomp profile of the container for its
+// capabilities, which some syscalls are only allowed with.
+func (o RunOptions) seccompFilter(caps []string) ([]seccomp.Instruction, error) {
+	p := o.seccompProfile()
+	if p == nil {
+		return nil, nil
+	}
+	return seccomp.Compile(p, caps)
+}
+
+// containerProcess is the init process of a running container together with
+// the helper processes serving it.
+type containerProcess struct {
+	*exec.Cmd
+	network *slirpNetwork
+	ports   *portProxy
+	cgroup  string
+	console *os.File        // master of the container's terminal with a TTY
+	output  <-chan struct{} // closed once the output of the terminal was copied
+	done    chan struct{}   // closed once the process was waited for
+}
+
+// Wait waits for the container to exit and stops its helpers.
+func (p *containerProcess) Wait() error {
+	err := p.Cmd.Wait()
+	close(p.done)
+	if p.console != nil {
+		<-p.output
+		p.console.Close()
+	}
+	if p.ports != nil {
+		p.ports.Stop()
+	}
+	if p.network != nil {
+		p.network.Stop()
+	}
+	if p.cgroup != "" {
+		if err := removeCgroup(p.cgroup); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	return err
+}
+
+// initPipeFd is the file descriptor the child reads its initConfig from.
+const initPipeFd = 3
+
+// idMapPipeFd is the file descriptor a helper started by runInUserNamespace
+// waits on until its ID mappings are written.
+const idMapPipeFd = 3
+
+// execFd is the file descriptor pce is re-executed from by `pce exec`.
+const execFd = 3
+
+// execPipeFd is the file descriptor `pce exec` reads its processConfig from.
+const execPipeFd = 4
+
+// readyPipeFd is the file descriptor the supervisor of a detached container
+// reports the start of the container on.
+const readyPipeFd = 3
+
+func (r *platformRuntime) Run(image string, command []string, opts RunOptions) error {
+	c, err := r.Create(image, command, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
+		return err
 	}
 
 	// Schedule cleanup - this will run after the process finishes
 	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to cleanup temp directory %s: %v\n", tempDir, err)
+		if err := removeContainerDir(c); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to cleanup container directory %s: %v\n", c.Dir(), err)
 		}
 	}()
 
-	// Retrieve image to the temporary directory
-	imagePath, config, err := img.RetrieveImage(image, true, tempDir)
+	return r.Start(c.ID, false)
+}
+
+func (r *platformRuntime) Create(image string, command []string, opts RunOptions) (*Container, error) {
+	if opts.Bundle != "" {
+		return createFromBundle(opts)
+	}
+
+	switch opts.Network {
+	case "", NetworkHost, NetworkPrivate:
+	default:
+		return nil, fmt.Errorf("unknown network mode %q, use %s or %s", opts.Network, NetworkHost, NetworkPrivate)
+	}
+	if err := opts.validateNamespaces(); err != nil {
+		return nil, err
+	}
+	if opts.StopSignal != "" {
+		if _, err := parseSignal(opts.StopSignal); err != nil {
+			return nil, err
+		}
+	}
+	if opts.StopTimeout != nil && *opts.StopTimeout < 0 {
+		return nil, fmt.Errorf("stop timeout must not be negative")
+	}
+	if err := opts.Resources.validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Ports) > 0 && opts.Network != NetworkPrivate {
+		return nil, fmt.Errorf("publishing ports needs --network %s, with the host network the ports of the container are reachable directly", NetworkPrivate)
+	}
+	if err := validateMounts(opts.Mounts); err != nil {
+		return nil, err
+	}
+	caps, err := opts.capabilities()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if _, err := opts.seccompFilter(caps); err != nil {
+		return nil, err
+	}
+
+	// Why subordinate IDs are unavailable is reported when the container
+	// starts, unpacking falls back quietly
+	ids, _ := idMappings()
+
+	// Unpack the image layers into the shared layer cache
+	layers, imageConfig, err := img.UnpackImage(image, ids, layerUnpacker(ids))
+	if err != nil {
+		return nil, err
 	}
 
 	if len(command) == 0 {
-		command = getDefaultCommand(config)
+		command = getDefaultCommand(imageConfig)
 		if len(command) == 0 {
-			return fmt.Errorf("no command specified and no default command found in image")
+			return nil, fmt.Errorf("no command specified and no default command found in image")
+		}
+	}
+
+	c, err := newContainer(image, command, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.Layers = layers
+	c.ImageConfig = imageConfig.Config
+
+	// Keep the layers from being garbage collected with the image
+	if err := img.RetainLayers(layers, c.ID); err != nil {
+		removeContainerDir(c)
+		return nil, err
+	}
+	if err := c.save(); err != nil {
+		removeContainerDir(c)
+		return nil, fmt.Errorf("failed to save container: %v", err)
+	}
+
+	return c, nil
+}
+
+func (r *platformRuntime) Start(container string, detach bool) error {
+	c, err := findContainer(container)
+	if err != nil {
+		return err
+	}
+	refreshState(c)
+	if c.State.Status == StatusRunning {
+		return fmt.Errorf("container %s is already running", c.Name)
+	}
+
+	if detach {
+		return startSupervisor(c)
+	}
+
+	// Handle signals in parent process
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	p, err := spawn(c, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	go forwardSignals(c, p, sigChan)
+
+	// The terminal of the container takes over line editing and signal
+	// keys, the host's terminal only passes the input on
+	if p.console != nil && term.IsTerminal(int(os.Stdin.Fd())) {
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to put terminal into raw mode: %v\n", err)
+		} else {
+			defer term.Restore(int(os.Stdin.Fd()), state)
+		}
+		go resizeConsole(p.console, os.Stdin, p.done)
+	}
+
+	err = p.Wait()
+	recordExit(c, p.Cmd)
+	if _, ok := err.(*exec.ExitError); ok {
+		return exitErrorOf(c.State.ExitCode)
+	}
+	return err
+}
+
+// spawn starts the init process of the container with the given standard
+// streams and records the container as running.
+func spawn(c *Container, stdin io.Reader, stdout, stderr io.Writer) (*containerProcess, error) {
+	ids, err := idMappings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, only root is mapped into the container\n", err)
+	}
+
+	// The cached layers stay read-only, changes of the container go to its
+	// own upper directory. The child assembles the root filesystem from both.
+	config := &initConfig{
+		Layers:        c.Layers,
+		UpperDir:      c.UpperDir(),
+		WorkDir:       c.WorkDir(),
+		Network:       c.Options.Network,
+		NoPivot:       c.Options.NoPivot,
+		ReadOnly:      c.Options.ReadOnly,
+		Hostname:      defaultHostname,
+		TimeNS:        c.Options.privateTimeNS(),
+		Init:          c.Options.Init,
+		MaskedPaths:   defaultMaskedPaths,
+		ReadonlyPaths: defaultReadonlyPaths,
+		Process:       newProcessConfig(c),
+	}
+	if c.Spec != nil {
+		applyBundle(config, c.Spec, c.Options.Bundle)
+	}
+	config.Process.Terminal = c.Options.TTY
+	if f, ok := stdin.(*os.File); ok && c.Options.TTY {
+		if width, height, err := term.GetSize(int(f.Fd())); err == nil {
+			config.Process.ConsoleSize = &consoleSize{Height: uint16(height), Width: uint16(width)}
+		}
+	}
+
+	for _, m := range c.Options.Mounts {
+		if m.Type == MountVolume {
+			dir, err := volumePath(m.Source)
+			if err != nil {
+				return nil, err
+			}
+			m.Source = dir
 		}
+		config.Mounts = append(config.Mounts, m)
+	}
+
+	cloneFlags := syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER
+	if c.Options.Network == NetworkPrivate {
+		cloneFlags |= syscall.CLONE_NEWNET
+	}
+	if c.Options.privateIPC() {
+		cloneFlags |= syscall.CLONE_NEWIPC
+	}
+	if c.Options.privateCgroupNS() {
+		cloneFlags |= syscall.CLONE_NEWCGROUP
 	}
 
 	// restart myself with the child flag /proc/self/exe is a symbolic link to the current process
-	args := append([]string{"internalrun", imagePath}, command...)
+	args := append([]string{"internalrun", c.RootfsDir()}, c.Command...)
 
 	cmd := exec.Command("/proc/self/exe", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if c.Options.TTY {
+		// The command reads from and writes to its terminal, which is the
+		// only writer of stdout. Messages of pce before the terminal was set
+		// up go to stderr.
+		cmd.Stdin = nil
+		cmd.Stdout = stderr
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		// NEWNS => used for mounting
-		Cloneflags:   syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER,
-		Credential:   &syscall.Credential{Uid: 0, Gid: 0},                                    // make root in container
-		UidMappings:  []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}, // outside of container be the user
-		GidMappings:  []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		Cloneflags:   uintptr(cloneFlags),
 		Unshareflags: syscall.CLONE_NEWNS, // remove the other mounts
 	}
+	if needsIDMapHelpers(ids) {
+		// The mappings are written by newuidmap and newgidmap once the child
+		// runs, until then it keeps its capabilities as ambient ones
+		cmd.SysProcAttr.AmbientCaps = allCapabilities()
+	} else {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0} // make root in container
+		cmd.SysProcAttr.UidMappings = sysProcIDMaps(ids.UIDs)            // outside of container be the user
+		cmd.SysProcAttr.GidMappings = sysProcIDMaps(ids.GIDs)
+	}
+
+	initReader, initWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create init pipe: %v", err)
+	}
+	cmd.ExtraFiles = []*os.File{initReader}
+
+	// The init process allocates the terminal in the container and sends
+	// it back over a socket
+	var consoleSocket, childSocket *os.File
+	if c.Options.TTY {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			initReader.Close()
+			initWriter.Close()
+			return nil, fmt.Errorf("failed to create console socket: %v", err)
+		}
+		consoleSocket = os.NewFile(uintptr(fds[0]), "console-socket")
+		defer consoleSocket.Close()
+		childSocket = os.NewFile(uintptr(fds[1]), "console-socket")
+		defer childSocket.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, childSocket)
+	}
+
+	p := &containerProcess{Cmd: cmd, done: make(chan struct{})}
+
+	// The child is started right inside its cgroup, so the limits apply
+	// before the command of the container runs
+	if c.Options.Resources != (Resources{}) {
+		p.cgroup, err = createCgroup(c.ID, c.Options.Resources)
+		if err != nil {
+			initReader.Close()
+			initWriter.Close()
+			return nil, fmt.Errorf("failed to set up cgroup: %v", err)
+		}
+		cgroupDir, err := os.Open(p.cgroup)
+		if err != nil {
+			initReader.Close()
+			initWriter.Close()
+			removeCgroup(p.cgroup)
+			return nil, err
+		}
+		defer cgroupDir.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+
+	listeners, err := listenPorts(c.Options.Ports)
+	if err != nil {
+		initReader.Close()
+		initWriter.Close()
+		if p.cgroup != "" {
+			removeCgroup(p.cgroup)
+		}
+		return nil, err
+	}
+
+	err = cmd.Start()
+	initReader.Close()
+	if childSocket != nil {
+		// Only the child keeps its end, so receiving ends once it exits
+		childSocket.Close()
+	}
+	if err != nil {
+		initWriter.Close()
+		for _, l := range listeners {
+			l.Close()
+		}
+		if p.cgroup != "" {
+			removeCgroup(p.cgroup)
+		}
+		return nil, err
+	}
+
+	// The child waits for its config, so the network is ready before the
+	// command of the container runs
+	if needsIDMapHelpers(ids) {
+		if err := writeIDMappings(cmd.Process.Pid, ids); err != nil {
+			initWriter.Close()
+			for _, l := range listeners {
+				l.Close()
+			}
+			cmd.Process.Kill()
+			p.Wait()
+			return nil, err
+		}
+	}
+	if c.Options.Network == NetworkPrivate {
+		p.network, err = startSlirp(cmd.Process.Pid)
+		if err == nil && len(listeners) > 0 {
+			p.ports, err = startPortProxy(cmd.Process.Pid, listeners)
+		}
+		if err != nil {
+			initWriter.Close()
+			for _, l := range listeners {
+				l.Close()
+			}
+			cmd.Process.Kill()
+			p.Wait()
+			return nil, err
+		}
+		if p.network != nil {
+			config.Nameserver = slirpNameserver
+		}
+	}
+
+	err = json.NewEncoder(initWriter).Encode(config)
+	initWriter.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		p.Wait()
+		return nil, fmt.Errorf("failed to send config to container: %v", err)
+	}
+
+	if consoleSocket != nil {
+		p.console, err = receiveConsole(consoleSocket)
+		if err != nil {
+			cmd.Process.Kill()
+			p.Wait()
+			return nil, err
+		}
+		p.output = attachConsole(p.console, stdin, stdout)
+	}
+
+	c.State = State{Status: StatusRunning, Pid: cmd.Process.Pid, Cgroup: p.cgroup, StartedAt: time.Now()}
+	if err := c.saveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save container state: %v\n", err)
+	}
+
+	return p, nil
+}
+
+// recordExit records the container as exited once its init process was
+// waited for.
+func recordExit(c *Container, cmd *exec.Cmd) {
+	c.State.Status = StatusExited
+	c.State.Pid = 0
+	c.State.ExitCode = exitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus))
+	c.State.FinishedAt = time.Now()
+	if err := c.saveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save container state: %v\n", err)
+	}
+}
+
+// forwardSignals stops the container once a signal arrives on sigChan,
+// with its stop signal first and SIGKILL once the stop timeout passed.
+func forwardSignals(c *Container, p *containerProcess, sigChan chan os.Signal) {
+	select {
+	case <-sigChan:
+	case <-p.done:
+		return
+	}
+	exited := func() bool {
+		select {
+		case <-p.done:
+			return true
+		default:
+			return false
+		}
+	}
+	if err := stopProcess(p.Process.Pid, c.stopSignal(), c.Options.stopTimeout(), exited); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// startSupervisor starts the container in the background under a supervisor
+// process, which outlives pce and captures the output of the container to
+// its log file. It returns once the container is running.
+func startSupervisor(c *Container) error {
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create ready pipe: %v", err)
+	}
+	defer readyReader.Close()
+
+	// The supervisor has no terminal, its own messages go to a file
+	output, err := os.OpenFile(filepath.Join(c.Dir(), "supervisor.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("failed to open supervisor log: %v", err)
+	}
+	defer output.Close()
+
+	cmd := exec.Command("/proc/self/exe", "internalsupervise", c.ID)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	// Detach from the terminal session, so closing it does not end the container
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start supervisor: %v", err)
+	}
+	defer cmd.Process.Release()
+
+	// The supervisor closes the pipe once the container runs, after writing
+	// the error if it could not be started
+	msg, err := io.ReadAll(readyReader)
+	if err != nil {
+		return fmt.Errorf("failed to wait for supervisor: %v", err)
+	}
+	if len(msg) > 0 {
+		return fmt.Errorf("%s", msg)
+	}
+
+	state, err := loadContainer(c.ID)
+	if err != nil {
+		return err
+	}
+	if state.State.Status == StatusCreated {
+		return fmt.Errorf("supervisor of container %s exited early, see %s", c.Name, output.Name())
+	}
+	return nil
+}
+
+func (r *platformRuntime) Supervise(container string) error {
+	ready := os.NewFile(readyPipeFd, "ready-pipe")
+	fail := func(err error) error {
+		fmt.Fprint(ready, err)
+		ready.Close()
+		return err
+	}
+
+	c, err := findContainer(container)
+	if err != nil {
+		return fail(err)
+	}
+
+	logs, err := openLogFile(c.LogPath())
+	if err != nil {
+		return fail(err)
+	}
+	defer logs.Close()
+	stdout, stderr := logs.stream("stdout"), logs.stream("stderr")
 
-	// Handle signals in parent process
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	signal.Ignore(syscall.SIGHUP)
 
-	if err := cmd.Start(); err != nil {
+	p, err := spawn(c, nil, stdout, stderr)
+	if err != nil {
+		return fail(err)
+	}
+	ready.Close()
+	go forwardSignals(c, p, sigChan)
+
+	err = p.Wait()
+
+	// The log is complete before the container is seen as exited
+	if err := stdout.Flush(); err != nil {
+		fmt.Printf("Warning: failed to write log: %v\n", err)
+	}
+	if err := stderr.Flush(); err != nil {
+		fmt.Printf("Warning: failed to write log: %v\n", err)
+	}
+	recordExit(c, p.Cmd)
+
+	return err
+}
+
+func (r *platformRuntime) Logs(container string, opts LogOptions) error {
+	c, err := findContainer(container)
+	if err != nil {
 		return err
 	}
 
-	// Signal handling goroutine
-	go func() {
-		<-sigChan
-		fmt.Println("\nReceived interrupt signal, shutting down container...")
+	running := func() bool {
+		current, err := loadContainer(c.ID)
+		if err != nil {
+			return false
+		}
+		refreshState(current)
+		return current.State.Status == StatusRunning
+	}
+
+	return readLogs(c.LogPath(), opts, os.Stdout, os.Stderr, running)
+}
+
+func (r *platformRuntime) List() ([]*Container, error) {
+	containers, err := listContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		refreshState(c)
+	}
+	return containers, nil
+}
 
-		// Send SIGTERM first
-		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-			fmt.Printf("Warning: failed to send SIGTERM: %v\n", err)
+func (r *platformRuntime) Remove(container string, force bool) error {
+	c, err := findContainer(container)
+	if err != nil {
+		return err
+	}
+
+	refreshState(c)
+	if c.State.Status == StatusRunning {
+		if !force {
+			return fmt.Errorf("container %s is running, stop it first or force removal", c.Name)
+		}
+		// Killing the init process tears down the whole PID namespace
+		if err := syscall.Kill(c.State.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to kill container %s: %v", c.Name, err)
 		}
+		// The process that started the container must not write into the
+		// removed directory
+		waitForExit(c)
+	}
 
-		// Wait 5 seconds for graceful shutdown
-		time.Sleep(5 * time.Second)
+	return removeContainerDir(c)
+}
 
-		// Force kill if still running
-		if err := cmd.Process.Kill(); err != nil {
-			fmt.Printf("Warning: failed to kill process: %v\n", err)
+func (r *platformRuntime) Stop(container string, timeout *int) error {
+	c, err := findContainer(container)
+	if err != nil {
+		return err
+	}
+	refreshState(c)
+	if c.State.Status != StatusRunning {
+		return nil
+	}
+
+	wait := c.Options.stopTimeout()
+	if timeout != nil {
+		wait = time.Duration(*timeout) * time.Second
+	}
+	exited := func() bool {
+		return !processAlive(c.State.Pid)
+	}
+	if err := stopProcess(c.State.Pid, c.stopSignal(), wait, exited); err != nil {
+		return fmt.Errorf("failed to stop container %s: %v", c.Name, err)
+	}
+	waitForExit(c)
+	return nil
+}
+
+func (r *platformRuntime) Kill(container string, signal string) error {
+	sig := syscall.SIGKILL
+	if signal != "" {
+		var err error
+		if sig, err = parseSignal(signal); err != nil {
+			return err
 		}
-	}()
+	}
+
+	c, err := findContainer(container)
+	if err != nil {
+		return err
+	}
+	refreshState(c)
+	if c.State.Status != StatusRunning {
+		return fmt.Errorf("container %s is not running", c.Name)
+	}
+	if err := syscall.Kill(c.State.Pid, sig); err != nil {
+		return fmt.Errorf("failed to signal container %s: %v", c.Name, err)
+	}
+	return nil
+}
 
-	return cmd.Wait()
+// waitForExit gives the process that started a container time to record
+// its exit.
+func waitForExit(c *Container) {
+	for i := 0; i < 20; i++ {
+		current, err := loadContainer(c.ID)
+		if err != nil || current.State.Status != StatusRunning {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (r *platformRuntime) Exec(container string, command []string) error {
+	c, err := findContainer(container)
+	if err != nil {
+		return err
+	}
+	refreshState(c)
+	if c.State.Status != StatusRunning {
+		return fmt.Errorf("container %s is not running", c.Name)
+	}
+
+	// Joining the user namespace with setns needs a single-threaded process,
+	// which a Go program never is. nsenter joins the namespaces of the
+	// container's init process for us and then re-executes pce inside them.
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return fmt.Errorf("exec needs nsenter from util-linux: %v", err)
+	}
+
+	// pce starts outside the container's mount namespace and joins it last,
+	// as its shared libraries are not available inside the container. It is
+	// executed through an inherited file descriptor, so it does not need to
+	// be reachable at the same path in the container's user namespace.
+	self, err := os.Open("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	defer self.Close()
+
+	args := []string{
+		"--target", strconv.Itoa(c.State.Pid),
+		"--user", "--uts", "--pid",
+		"--preserve-credentials",
+	}
+	// Containers without a private network share the host's, which
+	// unprivileged users cannot join
+	if c.Options.Network == NetworkPrivate {
+		args = append(args, "--net")
+	}
+	if c.Options.privateIPC() {
+		args = append(args, "--ipc")
+	}
+	if c.Options.privateCgroupNS() {
+		args = append(args, "--cgroup")
+	}
+	if c.Options.privateTimeNS() {
+		args = append(args, "--time="+timeNamespacePath(c.State.Pid))
+	}
+	args = append(args, "--", fmt.Sprintf("/proc/self/fd/%d", execFd), "internalexec", fmt.Sprintf("/proc/%d", c.State.Pid))
+	args = append(args, command...)
+
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create config pipe: %v", err)
+	}
+	defer configReader.Close()
+
+	cmd := exec.Command(nsenter, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{self, configReader}
+
+	// Processes run with exec count against the limits of the container
+	if c.State.Cgroup != "" {
+		cgroupDir, err := os.Open(c.State.Cgroup)
+		if err != nil {
+			return fmt.Errorf("failed to open cgroup of container %s: %v", c.Name, err)
+		}
+		defer cgroupDir.Close()
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroupDir.Fd())}
+	}
+
+	if err := cmd.Start(); err != nil {
+		configWriter.Close()
+		return err
+	}
+	configReader.Close()
+
+	err = json.NewEncoder(configWriter).Encode(newProcessConfig(c))
+	configWriter.Close()
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to send config to exec process: %v", err)
+	}
+	// nsenter exits with the status of the command, or kills itself with
+	// the signal that killed it
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return exitErrorOf(exitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus)))
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *platformRuntime) ExecChildProcess(path string, command []string) error {
+	// The executable of pce must not leak into the container
+	syscall.CloseOnExec(execFd)
+
+	pipe := os.NewFile(execPipeFd, "exec-pipe")
+	var config processConfig
+	err := json.NewDecoder(pipe).Decode(&config)
+	pipe.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read exec config: %v", err)
+	}
+
+	// path is the /proc directory of the container's init process
+	if err := joinRootfs(path); err != nil {
+		return fmt.Errorf("failed to join container: %v", err)
+	}
+
+	return execProcess(config, command, false)
+}
+
+// refreshState marks a running container as exited if its process is gone,
+// which happens when the pce process that started it was killed.
+func refreshState(c *Container) {
+	if c.State.Status != StatusRunning || processAlive(c.State.Pid) {
+		return
+	}
+
+	c.State.Status = StatusExited
+	c.State.Pid = 0
+	c.State.ExitCode = 255
+	c.State.FinishedAt = time.Now()
+	c.saveState()
+}
+
+// processAlive reports whether a process with the pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
 }
 
 func (r *platformRuntime) CreateChildProcess(path string, command []string) error {
-	fmt.Println("Current command: " + strings.Join(command, " "))
-	fmt.Println("Current path on host:" + path)
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
 
-	// Simple environment setup
-	os.Setenv("PATH", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+	// The ambient capabilities only bridged the time until the user
+	// namespace was mapped, the container is root in it now
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %v", err)
+	}
 
-	util.Must(syscall.Sethostname([]byte("container")))
-	util.Must(syscall.Chroot(path))
-	util.Must(os.Chdir("/"))
+	// Supplementary groups of the host are not mapped into the container,
+	// where they would still grant access. Setting them is denied when only
+	// root is mapped, the process keeps them then.
+	if err := syscall.Setgroups(nil); err != nil && err != syscall.EPERM {
+		return fmt.Errorf("failed to drop supplementary groups: %v", err)
+	}
 
-	// Create essential device files
-	os.MkdirAll("/dev", 0755)
-	os.MkdirAll("/proc", 0755)
+	// Setup errors are returned rather than panicking, so they end the
+	// container with ExitEngineError instead of an exit code of the command
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			return fmt.Errorf("failed to set hostname: %v", err)
+		}
+	}
+	if config.Network == NetworkPrivate {
+		if err := setupLoopback(); err != nil {
+			return fmt.Errorf("failed to set up loopback: %v", err)
+		}
+	}
+	if err := mountRootfs(path, config); err != nil {
+		return fmt.Errorf("failed to mount root filesystem: %v", err)
+	}
+	if err := mountDev(path); err != nil {
+		return fmt.Errorf("failed to mount /dev: %v", err)
+	}
+	if err := mountProc(path); err != nil {
+		return err
+	}
+	if err := mountVolumes(path, config.Mounts); err != nil {
+		return err
+	}
+	if err := enterRootfs(path, config.NoPivot); err != nil {
+		return fmt.Errorf("failed to enter root filesystem: %v", err)
+	}
+	if err := protectPaths(config.MaskedPaths, config.ReadonlyPaths); err != nil {
+		return err
+	}
 
-	// Create /dev/null
-	if f, err := os.Create("/dev/null"); err == nil {
-		f.Close()
+	if config.Nameserver != "" {
+		if err := writeResolvConf(config.Nameserver); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write /etc/resolv.conf: %v\n", err)
+		}
 	}
 
-	// Create /dev/urandom with some random data
-	if f, err := os.Create("/dev/urandom"); err == nil {
-		f.WriteString("random_data_placeholder_" + fmt.Sprintf("%d", time.Now().UnixNano()))
-		f.Close()
+	if config.ReadOnly {
+		// The working directory cannot be created once the root is read-only
+		if dir := config.Process.WorkingDir; dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create working directory %s: %v", dir, err)
+			}
+		}
+		if err := readonlyRootfs(); err != nil {
+			return err
+		}
 	}
 
-	// Create /dev/random with some random data
-	if f, err := os.Create("/dev/random"); err == nil {
-		f.WriteString("random_data_placeholder_" + fmt.Sprintf("%d", time.Now().UnixNano()))
-		f.Close()
+	if config.TimeNS {
+		if err := enterTimeNamespace(); err != nil {
+			return fmt.Errorf("failed to enter time namespace: %v", err)
+		}
 	}
 
-	// Create /dev/zero
-	if f, err := os.Create("/dev/zero"); err == nil {
-		f.Close()
+	if config.Process.Terminal {
+		if err := setupConsole(config.Process.User, config.Process.ConsoleSize); err != nil {
+			return err
+		}
 	}
 
-	util.Must(syscall.Mount("proc", "proc", "proc", 0, ""))
+	return execProcess(config.Process, command, config.Init)
+}
 
-	err := syscall.Exec(command[0], command, os.Environ())
-	return fmt.Errorf("exec failed: %v", err)
+// execProcess replaces the current process with the command of the
+// container, once the root filesystem of the container was entered. With
+// withInit the current process stays as the init of the container and runs
+// the command as its child instead.
+func execProcess(p processConfig, command []string, withInit bool) error {
+	user, err := lookupUser(p.User)
+	if err != nil {
+		return err
+	}
+	user.Groups = append(user.Groups, p.AdditionalGroups...)
+
+	env := p.Env
+	if _, ok := lookupEnv(env, "HOME"); !ok {
+		env = append(env, "HOME="+user.Home)
+	}
+
+	// Like other runtimes the working directory is created if the image
+	// does not contain it
+	if p.WorkingDir != "" {
+		if err := os.MkdirAll(p.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("failed to create working directory %s: %v", p.WorkingDir, err)
+		}
+		if err := os.Chdir(p.WorkingDir); err != nil {
+			return fmt.Errorf("failed to change to working directory %s: %v", p.WorkingDir, err)
+		}
+	}
+
+	cred, err := userCredential(p.User, user)
+	if err != nil {
+		return err
+	}
+
+	path, _ := lookupEnv(env, "PATH")
+	binary, err := lookPath(command[0], path)
+	if err != nil {
+		return &ExitError{Code: ExitNotFound, Err: err}
+	}
+	if err := setRlimits(p.Rlimits); err != nil {
+		return err
+	}
+
+	// The command is executed or started from this thread, which the
+	// capabilities, no_new_privs and the seccomp filter belong to
+	runtime.LockOSThread()
+	if err := limitCapabilities(p.Capabilities); err != nil {
+		return err
+	}
+	if p.NoNewPrivileges {
+		if err := setNoNewPrivileges(); err != nil {
+			return err
+		}
+	}
+
+	if withInit {
+		if len(p.Seccomp) > 0 {
+			if err := seccomp.Install(p.Seccomp); err != nil {
+				return err
+			}
+		}
+		var ambient []uintptr
+		if cred != nil {
+			ambient = capabilitiesOf(capabilityMask(p.AmbientCapabilities))
+		}
+		return runInit(binary, command, env, cred, ambient, p.Terminal)
+	}
+
+	// The filter is installed as late as possible, so it does not need to
+	// allow the syscalls switching users. Without no_new_privs installing it
+	// needs CAP_SYS_ADMIN, which is lost when switching away from root.
+	installLate := p.NoNewPrivileges
+	if len(p.Seccomp) > 0 && !installLate {
+		if err := seccomp.Install(p.Seccomp); err != nil {
+			return err
+		}
+	}
+	if err := switchUser(cred, p.AmbientCapabilities); err != nil {
+		return err
+	}
+	if len(p.Seccomp) > 0 && installLate {
+		if err := seccomp.Install(p.Seccomp); err != nil {
+			return err
+		}
+	}
+	err = syscall.Exec(binary, command, env)
+	return commandError(err)
+}
+
+// commandError turns an error executing the command into an ExitError with
+// the exit code shells use for it.
+func commandError(err error) error {
+	code := ExitCannotInvoke
+	if errors.Is(err, syscall.ENOENT) {
+		code = ExitNotFound
+	}
+	return &ExitError{Code: code, Err: fmt.Errorf("exec failed: %v", err)}
+}
+
+func readInitConfig() (*initConfig, error) {
+	pipe := os.NewFile(initPipeFd, "init-pipe")
+	defer pipe.Close()
+
+	var config initConfig
+	if err := json.NewDecoder(pipe).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to read container config: %v", err)
+	}
+	return &config, nil
+}
+
+func sysProcIDMaps(maps []img.IDMap) []syscall.SysProcIDMap {
+	result := make([]syscall.SysProcIDMap, 0, len(maps))
+	for _, m := range maps {
+		result = append(result, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return result
 }
 
 func getDefaultCommand(config *v1.ConfigFile) []string {
@@ -3,6 +3,7 @@ package runtime
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 // mockRuntime implements ContainerRuntime interface for testing
@@ -15,7 +16,9 @@ type mockRuntime struct {
 	shouldError         bool
 }
 
-func (m *mockRuntime) Run(image string, command []string) error {
+var _ ContainerRuntime = (*mockRuntime)(nil)
+
+func (m *mockRuntime) Run(image string, command []string, opts RunOptions) error {
 	m.runCalled = true
 	m.lastImage = image
 	m.lastCommand = command
@@ -25,6 +28,71 @@ func (m *mockRuntime) Run(image string, command []string) error {
 	return nil
 }
 
+func (m *mockRuntime) Create(image string, command []string, opts RunOptions) (*Container, error) {
+	if err := m.Run(image, command, opts); err != nil {
+		return nil, err
+	}
+	return &Container{Image: image, Command: command, Options: opts}, nil
+}
+
+func (m *mockRuntime) Spec(image string, command []string, opts RunOptions) error {
+	return m.Run(image, command, opts)
+}
+
+func (m *mockRuntime) Start(container string, detach bool) error {
+	if m.shouldError {
+		return fmt.Errorf("mock error")
+	}
+	return nil
+}
+
+func (m *mockRuntime) List() ([]*Container, error) {
+	return nil, nil
+}
+
+func (m *mockRuntime) Stop(container string, timeout *int) error {
+	return nil
+}
+
+func (m *mockRuntime) Kill(container string, signal string) error {
+	return nil
+}
+
+func (m *mockRuntime) Remove(container string, force bool) error {
+	if m.shouldError {
+		return fmt.Errorf("mock error")
+	}
+	return nil
+}
+
+func (m *mockRuntime) Exec(container string, command []string) error {
+	return nil
+}
+
+func (m *mockRuntime) ExecChildProcess(path string, command []string) error {
+	return nil
+}
+
+func (m *mockRuntime) ServePorts() error {
+	return nil
+}
+
+func (m *mockRuntime) UnpackLayer(dir string) error {
+	return nil
+}
+
+func (m *mockRuntime) CopyLayers(dest string, layers []string) error {
+	return nil
+}
+
+func (m *mockRuntime) Logs(container string, opts LogOptions) error {
+	return nil
+}
+
+func (m *mockRuntime) Supervise(container string) error {
+	return nil
+}
+
 func (m *mockRuntime) CreateChildProcess(path string, command []string) error {
 	m.createProcessCalled = true
 	m.lastPath = path
@@ -76,7 +144,7 @@ func TestRuntimeInterface(t *testing.T) {
 
 			// Test Run method
 			if tt.image != "" {
-				err := mock.Run(tt.image, tt.command)
+				err := mock.Run(tt.image, tt.command, RunOptions{})
 				if tt.shouldError && err == nil {
 					t.Error("expected error but got none")
 				}
@@ -120,3 +188,67 @@ func TestRuntimeInterface(t *testing.T) {
 		})
 	}
 }
+
+func TestNamespaceOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       RunOptions
+		wantIPC    bool
+		wantCgroup bool
+		wantTime   bool
+		wantErr    bool
+	}{
+		{name: "defaults", opts: RunOptions{}, wantIPC: true, wantCgroup: true},
+		{
+			name:       "all private",
+			opts:       RunOptions{IPC: NamespacePrivate, CgroupNS: NamespacePrivate, TimeNS: NamespacePrivate},
+			wantIPC:    true,
+			wantCgroup: true,
+			wantTime:   true,
+		},
+		{name: "all host", opts: RunOptions{IPC: NamespaceHost, CgroupNS: NamespaceHost, TimeNS: NamespaceHost}},
+		{name: "unknown mode", opts: RunOptions{CgroupNS: "shared"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validateNamespaces()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateNamespaces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := tt.opts.privateIPC(); got != tt.wantIPC {
+				t.Errorf("privateIPC() = %v, want %v", got, tt.wantIPC)
+			}
+			if got := tt.opts.privateCgroupNS(); got != tt.wantCgroup {
+				t.Errorf("privateCgroupNS() = %v, want %v", got, tt.wantCgroup)
+			}
+			if got := tt.opts.privateTimeNS(); got != tt.wantTime {
+				t.Errorf("privateTimeNS() = %v, want %v", got, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	zero, ten := 0, 10
+	tests := []struct {
+		name string
+		opts RunOptions
+		want time.Duration
+	}{
+		{name: "default", opts: RunOptions{}, want: defaultStopTimeout},
+		{name: "immediate", opts: RunOptions{StopTimeout: &zero}, want: 0},
+		{name: "configured", opts: RunOptions{StopTimeout: &ten}, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.stopTimeout(); got != tt.want {
+				t.Errorf("stopTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
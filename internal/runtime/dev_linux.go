@@ -0,0 +1,80 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// hostDevices are bind-mounted from the host into the container's /dev.
+// Creating device nodes is not allowed in a user namespace, binding them is.
+var hostDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// devSymlinks are the standard links every /dev has.
+var devSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// mountDev sets up a minimal /dev in rootfs on a fresh tmpfs, so the
+// container neither sees the devices of the host nor writes into the image.
+func mountDev(rootfs string) error {
+	dev := filepath.Join(rootfs, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("failed to mount /dev: %v", err)
+	}
+
+	for _, name := range hostDevices {
+		target := filepath.Join(dev, name)
+		if err := os.WriteFile(target, nil, 0666); err != nil {
+			return err
+		}
+		if err := syscall.Mount(filepath.Join("/dev", name), target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to bind mount /dev/%s: %v", name, err)
+		}
+	}
+
+	for name, target := range devSymlinks {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	mounts := []struct {
+		name   string
+		fstype string
+		flags  uintptr
+		data   string
+	}{
+		// A new instance keeps the pseudo terminals of the host out of reach
+		{"pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"},
+		{"shm", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "mode=1777,size=65536k"},
+		{"mqueue", "mqueue", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, ""},
+	}
+	for _, m := range mounts {
+		target := filepath.Join(dev, m.name)
+		if err := os.Mkdir(target, 0755); err != nil {
+			return err
+		}
+		err := syscall.Mount(m.name, target, m.fstype, m.flags, m.data)
+		if err == syscall.EPERM && m.fstype == "mqueue" {
+			// POSIX message queues can only be mounted in an IPC namespace
+			// owned by the container, without one they stay unavailable
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mount /dev/%s: %v", m.name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultPath is the PATH of containers whose image does not set one.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ParseEnv parses a variable given with -e as KEY=VALUE. A bare KEY takes
+// the value from the environment of pce and is dropped if it is not set
+// there, so host variables only end up in a container when asked for.
+func ParseEnv(value string) ([]string, error) {
+	key, _, found := strings.Cut(value, "=")
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return nil, fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", value)
+	}
+	if found {
+		return []string{value}, nil
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return []string{key + "=" + v}, nil
+	}
+	return nil, nil
+}
+
+// ParseEnvFile reads variables from a file with one KEY=VALUE per line, the
+// same as given with -e. Empty lines and lines starting with # are skipped.
+func ParseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimLeft(scanner.Text(), " \t")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		vars, err := ParseEnv(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		env = append(env, vars...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// mergeEnv combines lists of KEY=VALUE variables, where later lists override
+// the variables of earlier ones. Variables keep the position they first
+// appeared at.
+func mergeEnv(lists ...[]string) []string {
+	var env []string
+	index := map[string]int{}
+	for _, list := range lists {
+		for _, kv := range list {
+			key, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[key]; ok {
+				env[i] = kv
+				continue
+			}
+			index[key] = len(env)
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// lookupEnv returns the value of key in env.
+func lookupEnv(env []string, key string) (string, bool) {
+	for _, kv := range env {
+		if k, v, _ := strings.Cut(kv, "="); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// lookPath searches the directories of path for an executable named file,
+// like exec.LookPath does with the PATH of the current process.
+func lookPath(file, path string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in $PATH", file)
+}
@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("PCE_TEST_HOST", "host value")
+	os.Unsetenv("PCE_TEST_UNSET")
+
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{input: "KEY=value", want: []string{"KEY=value"}},
+		{input: "KEY=a=b", want: []string{"KEY=a=b"}},
+		{input: "EMPTY=", want: []string{"EMPTY="}},
+		{input: "PCE_TEST_HOST", want: []string{"PCE_TEST_HOST=host value"}},
+		{input: "PCE_TEST_UNSET", want: nil},
+		{input: "=value", wantErr: true},
+		{input: "MY KEY=value", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseEnv(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	t.Setenv("PCE_TEST_HOST", "from host")
+
+	dir, err := os.MkdirTemp("", "pce-env-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "env")
+	content := "# comment\nFOO=bar\n\n  BAZ=with spaces \nPCE_TEST_HOST\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatalf("ParseEnvFile() error = %v", err)
+	}
+	want := []string{"FOO=bar", "BAZ=with spaces ", "PCE_TEST_HOST=from host"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEnvFile() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("FOO=bar\n=broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseEnvFile(path); err == nil {
+		t.Error("ParseEnvFile() expected error for invalid line")
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	got := mergeEnv(
+		[]string{"PATH=/bin", "HOSTNAME=container"},
+		[]string{"PATH=/usr/bin", "LANG=C.UTF-8"},
+		[]string{"HOSTNAME=web", "EMPTY="},
+	)
+	want := []string{"PATH=/usr/bin", "HOSTNAME=web", "LANG=C.UTF-8", "EMPTY="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeEnv() = %q, want %q", got, want)
+	}
+
+	if v, ok := lookupEnv(got, "HOSTNAME"); !ok || v != "web" {
+		t.Errorf("lookupEnv(HOSTNAME) = %q, %v, want web, true", v, ok)
+	}
+	if _, ok := lookupEnv(got, "HOME"); ok {
+		t.Error("lookupEnv(HOME) found a variable that is not set")
+	}
+}
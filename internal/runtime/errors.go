@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes reserved for failures of pce itself, the same as Docker's. Every
+// other exit code of pce run and pce exec is the exit status of the command.
+const (
+	// ExitEngineError is returned when pce failed before the command ran
+	ExitEngineError = 125
+	// ExitCannotInvoke is returned when the command could not be executed
+	ExitCannotInvoke = 126
+	// ExitNotFound is returned when the command was not found
+	ExitNotFound = 127
+)
+
+// ExitError reports that a container or a command run in it did not exit
+// successfully. Code is the exit status, 128 plus the signal number for
+// processes killed by a signal. Err is the reason if the command could not be
+// run at all and nil if the command exited on its own.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code pce exits with for err: 0 without an error,
+// the code of an ExitError and ExitEngineError for every other error.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return ExitEngineError
+}
+
+// exitErrorOf returns an ExitError for a non-zero exit code and nil otherwise.
+func exitErrorOf(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return &ExitError{Code: code}
+}
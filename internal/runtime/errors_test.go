@@ -0,0 +1,40 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, 0},
+		{"container exit", &ExitError{Code: 3}, 3},
+		{"signal", &ExitError{Code: 137}, 137},
+		{"not found", &ExitError{Code: ExitNotFound, Err: errors.New("not found")}, ExitNotFound},
+		{"wrapped", fmt.Errorf("exec: %w", &ExitError{Code: ExitCannotInvoke}), ExitCannotInvoke},
+		{"engine error", errors.New("failed to unpack image"), ExitEngineError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitErrorOf(t *testing.T) {
+	if err := exitErrorOf(0); err != nil {
+		t.Errorf("exitErrorOf(0) = %v, want nil", err)
+	}
+	err := exitErrorOf(2)
+	if err == nil || err.Error() != "exit status 2" {
+		t.Errorf("exitErrorOf(2) = %v, want exit status 2", err)
+	}
+}
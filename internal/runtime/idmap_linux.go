@@ -0,0 +1,208 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	img "github.com/troppes/portable-container-engine/internal/image"
+)
+
+// idMappings returns the ID mappings of the user namespace containers run
+// in. Root maps all IDs onto themselves. Other users map the container's root
+// onto themselves and the other IDs onto their subordinate IDs, which needs
+// newuidmap and newgidmap. When that is not possible only root is mapped and
+// the reason is returned along with the fallback.
+func idMappings() (*img.IDMappings, error) {
+	if os.Geteuid() == 0 {
+		return img.HostIDMappings(), nil
+	}
+
+	ids, err := img.SubIDMappings()
+	if err != nil {
+		return img.SingleIDMappings(), err
+	}
+	for _, helper := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(helper); err != nil {
+			return img.SingleIDMappings(), fmt.Errorf("subordinate IDs need %s from the uidmap package", helper)
+		}
+	}
+	return ids, nil
+}
+
+// needsIDMapHelpers reports whether the mappings can only be written by the
+// setuid helpers newuidmap and newgidmap. Without privileges a process may
+// only map its own IDs.
+func needsIDMapHelpers(ids *img.IDMappings) bool {
+	return os.Geteuid() != 0 && (len(ids.UIDs) > 1 || len(ids.GIDs) > 1)
+}
+
+// writeIDMappings sets up the user namespace of pid with newuidmap and
+// newgidmap.
+func writeIDMappings(pid int, ids *img.IDMappings) error {
+	if err := runIDMapHelper("newuidmap", pid, ids.UIDs); err != nil {
+		return err
+	}
+	return runIDMapHelper("newgidmap", pid, ids.GIDs)
+}
+
+func runIDMapHelper(helper string, pid int, maps []img.IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	out, err := exec.Command(helper, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", helper, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ownIDMappings returns the IDs mapped into the user namespace of the
+// process, each onto itself.
+func ownIDMappings() (*img.IDMappings, error) {
+	ids := &img.IDMappings{}
+	for file, maps := range map[string]*[]img.IDMap{"/proc/self/uid_map": &ids.UIDs, "/proc/self/gid_map": &ids.GIDs} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var inside, outside, size int
+			if _, err := fmt.Sscan(line, &inside, &outside, &size); err != nil {
+				return nil, fmt.Errorf("invalid line %q in %s: %v", line, file, err)
+			}
+			*maps = append(*maps, img.IDMap{ContainerID: inside, HostID: inside, Size: size})
+		}
+	}
+	return ids, nil
+}
+
+// runInUserNamespace runs pce with args as root of a new user namespace with
+// the ID mappings of containers, where the IDs of ids can be assigned. The
+// helper waits on idMapPipeFd until the mappings are written.
+func runInUserNamespace(ids *img.IDMappings, stdin io.Reader, args ...string) error {
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	if needsIDMapHelpers(ids) {
+		// Like the container, the helper keeps its capabilities as ambient
+		// ones until newuidmap and newgidmap ran
+		cmd.SysProcAttr.AmbientCaps = allCapabilities()
+	} else {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+		cmd.SysProcAttr.UidMappings = sysProcIDMaps(ids.UIDs)
+		cmd.SysProcAttr.GidMappings = sysProcIDMaps(ids.GIDs)
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd.ExtraFiles = []*os.File{ready}
+
+	err = cmd.Start()
+	ready.Close()
+	if err != nil {
+		readyWriter.Close()
+		return err
+	}
+
+	if needsIDMapHelpers(ids) {
+		if err := writeIDMappings(cmd.Process.Pid, ids); err != nil {
+			cmd.Process.Kill()
+			readyWriter.Close()
+			cmd.Wait()
+			return err
+		}
+	}
+	readyWriter.Close()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v", args[0], err)
+	}
+	return nil
+}
+
+// waitForIDMappings blocks a helper started by runInUserNamespace until it is
+// root in its user namespace.
+func waitForIDMappings() error {
+	pipe := os.NewFile(idMapPipeFd, "idmap-pipe")
+	defer pipe.Close()
+	_, err := io.Copy(io.Discard, pipe)
+	return err
+}
+
+// layerUnpacker returns how layers are unpacked for containers with ids.
+// Subordinate IDs can only be assigned by root of a user namespace mapping
+// them, so such layers are unpacked by pce running in one. Other layers are
+// unpacked by this process.
+func layerUnpacker(ids *img.IDMappings) img.Unpacker {
+	if !needsIDMapHelpers(ids) {
+		return nil
+	}
+	return func(r io.Reader, dir string) error {
+		return runInUserNamespace(ids, r, "internalunpack", dir)
+	}
+}
+
+// copyLayers copies the layers unpacked for ids onto dest, in their user
+// namespace like layerUnpacker if they are owned by subordinate IDs.
+func copyLayers(ids *img.IDMappings, layers []string, dest string) error {
+	if !needsIDMapHelpers(ids) {
+		return img.CopyLayers(layers, dest)
+	}
+	return runInUserNamespace(ids, nil, append([]string{"internalcopylayers", dest}, layers...)...)
+}
+
+func (r *platformRuntime) UnpackLayer(dir string) error {
+	if err := waitForIDMappings(); err != nil {
+		return err
+	}
+
+	// The IDs recorded in the layer are assigned as they are, the user
+	// namespace maps them onto the IDs of the host
+	ids, err := ownIDMappings()
+	if err != nil {
+		return err
+	}
+	if err := img.UnpackLayer(os.Stdin, dir, ids); err != nil {
+		// Files owned by subordinate IDs can only be removed from in here
+		img.RemoveAll(filepath.Join(dir, "rootfs"))
+		return err
+	}
+	return nil
+}
+
+func (r *platformRuntime) CopyLayers(dest string, layers []string) error {
+	if err := waitForIDMappings(); err != nil {
+		return err
+	}
+	return img.CopyLayers(layers, dest)
+}
+
+// allCapabilities returns every capability the kernel knows. The init process
+// of a container keeps them as ambient capabilities while it is started
+// without ID mappings, an execve would drop them otherwise.
+func allCapabilities() []uintptr {
+	last := 40 // CAP_CHECKPOINT_RESTORE
+	if data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			last = n
+		}
+	}
+	caps := make([]uintptr, 0, last+1)
+	for c := 0; c <= last; c++ {
+		caps = append(caps, uintptr(c))
+	}
+	return caps
+}
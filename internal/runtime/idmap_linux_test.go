@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	img "github.com/troppes/portable-container-engine/internal/image"
+)
+
+// TestMain lets the test binary, which is /proc/self/exe in tests, serve as
+// the helpers pce re-executes itself as.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 2 && os.Args[1] == "internalunpack" {
+		if err := (&platformRuntime{}).UnpackLayer(os.Args[2]); err != nil {
+			fmt.Printf("Error unpacking layer: %v\n", err)
+			os.Exit(ExitEngineError)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestUnpackLayerOwnership(t *testing.T) {
+	ids, err := idMappings()
+	if os.Geteuid() == 0 {
+		// Root writes the mappings itself, what newuidmap does for others
+		ids = &img.IDMappings{
+			UIDs: []img.IDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+			GIDs: []img.IDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		}
+	} else if err != nil {
+		t.Skipf("subordinate IDs not available: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "nginx", Typeflag: tar.TypeReg, Mode: 0644, Uid: 101, Gid: 101},
+	} {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+	}
+	tw.Close()
+
+	dir := t.TempDir()
+	if err := runInUserNamespace(ids, &buf, "internalunpack", dir); err != nil {
+		t.Fatalf("unpacking in the user namespace failed: %v", err)
+	}
+
+	for path, id := range map[string]int{"etc/passwd": 0, "nginx": 101} {
+		info, err := os.Stat(filepath.Join(dir, "rootfs", path))
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", path, err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		uid, gid, _ := ids.HostIDs(id, id)
+		if int(stat.Uid) != uid || int(stat.Gid) != gid {
+			t.Errorf("owner of %s is %d:%d, want %d:%d", path, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+}
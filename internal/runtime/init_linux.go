@@ -0,0 +1,83 @@
+//go:build linux
+
+package runtime
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runInit runs the command of the container as a child of the current
+// process, which stays as PID 1 of the container. The kernel does not apply
+// the default action of signals to PID 1, so a command that does not handle
+// SIGTERM could not be stopped, and orphaned processes are reparented to it
+// to be reaped. runInit forwards all signals to the command, reaps every
+// process that exits and exits with the status of the command. The command
+// keeps the ambient capabilities when it runs as another user than root.
+// With a terminal the command runs in the foreground process group, which
+// gets the signals of the terminal's keys directly.
+func runInit(binary string, command, env []string, cred *syscall.Credential, ambient []uintptr, terminal bool) error {
+	sigChan := make(chan os.Signal, 32)
+	signal.Notify(sigChan)
+
+	cmd := &exec.Cmd{
+		Path:        binary,
+		Args:        command,
+		Env:         env,
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{Credential: cred, AmbientCaps: ambient},
+	}
+	if terminal {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+	if err := cmd.Start(); err != nil {
+		return commandError(err)
+	}
+	pid := cmd.Process.Pid
+
+	for sig := range sigChan {
+		switch sig {
+		case syscall.SIGCHLD:
+			if status, ok := reap(pid); ok {
+				os.Exit(exitStatus(status))
+			}
+		case syscall.SIGURG:
+			// Sent by the Go runtime to preempt goroutines
+		default:
+			syscall.Kill(pid, sig.(syscall.Signal))
+		}
+	}
+	return nil
+}
+
+// reap waits for all exited children and returns the status of pid once it
+// was among them.
+func reap(pid int) (syscall.WaitStatus, bool) {
+	for {
+		var status syscall.WaitStatus
+		child, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || child <= 0 {
+			return 0, false
+		}
+		if child == pid {
+			return status, true
+		}
+	}
+}
+
+// exitStatus converts a wait status into an exit code the way shells do,
+// 128 plus the signal number for processes killed by a signal.
+func exitStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
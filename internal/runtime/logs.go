@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxLogLine splits overly long lines into several log entries, so a process
+// writing without newlines cannot grow the buffer without limit.
+const maxLogLine = 16 * 1024
+
+// logFollowInterval is how often a followed log is checked for new entries.
+const logFollowInterval = 250 * time.Millisecond
+
+// LogOptions selects the log entries `pce logs` prints.
+type LogOptions struct {
+	Follow bool
+	Since  time.Time
+	Tail   int // number of entries from the end, negative for all
+}
+
+// logEntry is one line of container output. The log file holds one JSON
+// encoded entry per line.
+type logEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func (c *Container) LogPath() string {
+	return filepath.Join(c.Dir(), "container.log")
+}
+
+// ParseSince parses the --since value of `pce logs`, either a duration
+// relative to now like 10m or an RFC 3339 timestamp.
+func ParseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a duration like 10m or an RFC 3339 timestamp", s)
+	}
+	return t, nil
+}
+
+// logFile writes the output of a container to its log file.
+type logFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openLogFile(path string) (*logFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %v", err)
+	}
+	return &logFile{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (l *logFile) write(stream string, line []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(logEntry{Log: string(line), Stream: stream, Time: time.Now().UTC()})
+}
+
+func (l *logFile) Close() error {
+	return l.f.Close()
+}
+
+// stream returns a writer that logs everything written to it line by line
+// as the named stream.
+func (l *logFile) stream(name string) *logStream {
+	return &logStream{file: l, name: name}
+}
+
+type logStream struct {
+	file *logFile
+	name string
+	buf  []byte
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 && len(s.buf) < maxLogLine {
+			return len(p), nil
+		}
+		if i < 0 || i >= maxLogLine {
+			i = maxLogLine - 1
+		}
+		if err := s.file.write(s.name, s.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+}
+
+// Flush logs a trailing line that was not terminated by a newline.
+func (s *logStream) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.file.write(s.name, s.buf)
+	s.buf = nil
+	return err
+}
+
+// readLogs prints the log entries at path to stdout and stderr. When following
+// it keeps waiting for new entries as long as running reports true.
+func readLogs(path string, opts LogOptions, stdout, stderr io.Writer, running func() bool) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Only detached containers are logged
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	print := func(line []byte) error {
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("invalid log entry: %v", err)
+		}
+		if entry.Time.Before(opts.Since) {
+			return nil
+		}
+		w := stdout
+		if entry.Stream == "stderr" {
+			w = stderr
+		}
+		_, err := io.WriteString(w, entry.Log)
+		return err
+	}
+
+	// Read the existing entries first, so only the last ones are printed
+	// with --tail
+	reader := bufio.NewReader(f)
+	var lines [][]byte
+	var pending []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		lines = append(lines, pending)
+		pending = nil
+	}
+	if opts.Tail >= 0 && len(lines) > opts.Tail {
+		lines = lines[len(lines)-opts.Tail:]
+	}
+	for _, line := range lines {
+		if err := print(line); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Follow {
+		return nil
+	}
+
+	stopped := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err == nil {
+			if err := print(pending); err != nil {
+				return err
+			}
+			pending = nil
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		if stopped {
+			return nil
+		}
+		if !running() {
+			// Read once more for entries written before the container stopped
+			stopped = true
+			continue
+		}
+		time.Sleep(logFollowInterval)
+	}
+}
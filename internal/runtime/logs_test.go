@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-logs-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "container.log")
+	logs, err := openLogFile(path)
+	if err != nil {
+		t.Fatalf("openLogFile() error = %v", err)
+	}
+	stdout, stderr := logs.stream("stdout"), logs.stream("stderr")
+
+	// Lines are split across writes and interleaved between the streams
+	for _, w := range []struct {
+		stream *logStream
+		data   string
+	}{
+		{stdout, "one\ntw"},
+		{stderr, "error\n"},
+		{stdout, "o\nthree\n"},
+		{stdout, strings.Repeat("x", maxLogLine+1)},
+	} {
+		if _, err := w.stream.Write([]byte(w.data)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := stdout.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	logs.Close()
+
+	long := strings.Repeat("x", maxLogLine+1)
+
+	tests := []struct {
+		name       string
+		opts       LogOptions
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "all",
+			opts:       LogOptions{Tail: -1},
+			wantStdout: "one\ntwo\nthree\n" + long,
+			wantStderr: "error\n",
+		},
+		{
+			name:       "tail",
+			opts:       LogOptions{Tail: 3},
+			wantStdout: "three\n" + long,
+		},
+		{
+			name: "since",
+			opts: LogOptions{Tail: -1, Since: time.Now().Add(time.Minute)},
+		},
+		{
+			name:       "follow stopped container",
+			opts:       LogOptions{Tail: 0, Follow: true},
+			wantStdout: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotStdout, gotStderr bytes.Buffer
+			err := readLogs(path, tt.opts, &gotStdout, &gotStderr, func() bool { return false })
+			if err != nil {
+				t.Fatalf("readLogs() error = %v", err)
+			}
+			if gotStdout.String() != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", gotStdout.String(), tt.wantStdout)
+			}
+			if gotStderr.String() != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", gotStderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: time.Time{}},
+		{name: "timestamp", input: "2025-01-02T15:04:05Z", want: time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "invalid", input: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSince(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSince() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseSince() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := ParseSince("10m")
+	if err != nil {
+		t.Fatalf("ParseSince() error = %v", err)
+	}
+	if d := time.Since(got); d < 10*time.Minute || d > 11*time.Minute {
+		t.Errorf("ParseSince(10m) = %v ago, want 10m", d)
+	}
+}
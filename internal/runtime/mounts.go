@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	util "github.com/troppes/portable-container-engine/internal/util"
+)
+
+// Types of mounts
+const (
+	// MountBind mounts a file or directory of the host.
+	MountBind = "bind"
+	// MountVolume mounts a named volume kept in the data root of pce.
+	MountVolume = "volume"
+	// MountTmpfs mounts an empty tmpfs.
+	MountTmpfs = "tmpfs"
+)
+
+// Mount is a file system mounted into a container on top of its image.
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	// NoSuid, NoDev and NoExec are the flags of mounts from bundles, a
+	// tmpfs is always nosuid and nodev.
+	NoSuid bool `json:"noSuid,omitempty"`
+	NoDev  bool `json:"noDev,omitempty"`
+	NoExec bool `json:"noExec,omitempty"`
+	// Options are the mount options of a tmpfs, like size=64m.
+	Options string `json:"options,omitempty"`
+}
+
+// ParseVolume parses a mount given with -v as source:destination[:ro|:rw].
+// An absolute source is a bind mount of the host, any other source is the
+// name of a volume, which is created when it does not exist yet.
+func ParseVolume(spec string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Mount{}, fmt.Errorf("invalid volume %q, expected source:destination[:ro]", spec)
+	}
+
+	m := Mount{Type: MountVolume, Source: parts[0], Destination: parts[1]}
+	if filepath.IsAbs(m.Source) {
+		m.Type = MountBind
+		m.Source = filepath.Clean(m.Source)
+	} else if !validName.MatchString(m.Source) {
+		return Mount{}, fmt.Errorf("invalid volume %q, the source must be an absolute path or a volume name", spec)
+	}
+
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return Mount{}, fmt.Errorf("invalid mode %q in volume %q, use ro or rw", parts[2], spec)
+		}
+	}
+
+	if err := validateDestination(m.Destination); err != nil {
+		return Mount{}, fmt.Errorf("invalid volume %q: %v", spec, err)
+	}
+	m.Destination = path.Clean(m.Destination)
+	return m, nil
+}
+
+// ParseTmpfs parses a mount given with --tmpfs as destination[:options],
+// where the options are those of a tmpfs mount like size=64m,mode=1777.
+func ParseTmpfs(spec string) (Mount, error) {
+	destination, options, _ := strings.Cut(spec, ":")
+	if err := validateDestination(destination); err != nil {
+		return Mount{}, fmt.Errorf("invalid tmpfs %q: %v", spec, err)
+	}
+
+	m := Mount{Type: MountTmpfs, Destination: path.Clean(destination)}
+	var data []string
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case "":
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+			m.ReadOnly = false
+		default:
+			data = append(data, option)
+		}
+	}
+	m.Options = strings.Join(data, ",")
+	return m, nil
+}
+
+func validateDestination(destination string) error {
+	if !path.IsAbs(destination) {
+		return fmt.Errorf("destination %q is not an absolute path", destination)
+	}
+	if path.Clean(destination) == "/" {
+		return fmt.Errorf("destination must not be /")
+	}
+	return nil
+}
+
+// validateMounts checks the mounts of a new container before it is created.
+func validateMounts(mounts []Mount) error {
+	seen := map[string]bool{}
+	for _, m := range mounts {
+		if seen[m.Destination] {
+			return fmt.Errorf("duplicate mount point %s", m.Destination)
+		}
+		seen[m.Destination] = true
+
+		if m.Type == MountBind {
+			if _, err := os.Stat(m.Source); err != nil {
+				return fmt.Errorf("invalid bind mount source: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func volumesDir() string {
+	return filepath.Join(util.DataRoot(), "volumes")
+}
+
+// volumePath returns the directory of the named volume on the host and
+// creates it if needed. Volumes outlive the containers using them.
+func volumePath(name string) (string, error) {
+	dir := filepath.Join(volumesDir(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create volume %s: %v", name, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountVolumes mounts the bind mounts, volumes and tmpfs mounts of the
+// container into rootfs before the root switch. Volumes must already be
+// resolved to their directory on the host.
+func mountVolumes(rootfs string, mounts []Mount) error {
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	// Mount points are created and opened inside rootfs, so symlinks in the
+	// image cannot redirect a mount to the host
+	root, err := os.OpenRoot(rootfs)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for _, m := range mounts {
+		if err := mountVolume(root, m); err != nil {
+			return fmt.Errorf("failed to mount %s: %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+func mountVolume(root *os.Root, m Mount) error {
+	dest := strings.TrimPrefix(m.Destination, "/")
+
+	isDir := true
+	if m.Type == MountBind {
+		info, err := os.Stat(m.Source)
+		if err != nil {
+			return err
+		}
+		isDir = info.IsDir()
+	}
+
+	target, err := mountPoint(root, dest, isDir)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	targetPath := fmt.Sprintf("/proc/self/fd/%d", target.Fd())
+
+	switch m.Type {
+	case MountTmpfs:
+		flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+		if m.ReadOnly {
+			flags |= syscall.MS_RDONLY
+		}
+		if m.NoExec {
+			flags |= syscall.MS_NOEXEC
+		}
+		return syscall.Mount("tmpfs", targetPath, "tmpfs", flags, m.Options)
+	case MountBind, MountVolume:
+		if err := syscall.Mount(m.Source, targetPath, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return err
+		}
+		attr := &unix.MountAttr{Attr_set: m.mountAttr()}
+		if attr.Attr_set == 0 {
+			return nil
+		}
+		// The descriptor still refers to the directory below the new
+		// mount, the mount itself is reached by opening the path again
+		mounted, err := root.Open(dest)
+		if err != nil {
+			return err
+		}
+		defer mounted.Close()
+		// Unlike a remount, mount_setattr keeps the flags the kernel locked
+		// when the mount was copied into the user namespace
+		if err := unix.MountSetattr(int(mounted.Fd()), "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE, attr); err != nil {
+			return fmt.Errorf("failed to set mount flags: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+}
+
+// mountAttr returns the mount attributes of a bind mount or volume.
+func (m Mount) mountAttr() uint64 {
+	var attr uint64
+	if m.ReadOnly {
+		attr |= unix.MOUNT_ATTR_RDONLY
+	}
+	if m.NoSuid {
+		attr |= unix.MOUNT_ATTR_NOSUID
+	}
+	if m.NoDev {
+		attr |= unix.MOUNT_ATTR_NODEV
+	}
+	if m.NoExec {
+		attr |= unix.MOUNT_ATTR_NOEXEC
+	}
+	return attr
+}
+
+// mountPoint creates the directory or empty file dest inside root to mount
+// on and opens it.
+func mountPoint(root *os.Root, dest string, isDir bool) (*os.File, error) {
+	if isDir {
+		if err := root.MkdirAll(dest, 0755); err != nil {
+			return nil, err
+		}
+		return root.Open(dest)
+	}
+
+	if err := root.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	return root.OpenFile(dest, os.O_RDONLY|os.O_CREATE, 0644)
+}
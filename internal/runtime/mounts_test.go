@@ -0,0 +1,107 @@
+package runtime
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Mount
+		wantErr bool
+	}{
+		{spec: "/srv/data:/data", want: Mount{Type: MountBind, Source: "/srv/data", Destination: "/data"}},
+		{spec: "/srv/data/:/data/:ro", want: Mount{Type: MountBind, Source: "/srv/data", Destination: "/data", ReadOnly: true}},
+		{spec: "cache:/var/cache:rw", want: Mount{Type: MountVolume, Source: "cache", Destination: "/var/cache"}},
+		{spec: "/data", wantErr: true},
+		{spec: "cache:relative", wantErr: true},
+		{spec: "cache:/", wantErr: true},
+		{spec: "./cache:/data", wantErr: true},
+		{spec: "cache:/data:rx", wantErr: true},
+		{spec: "a:/b:ro:extra", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseVolume(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseVolume() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Mount
+		wantErr bool
+	}{
+		{spec: "/run", want: Mount{Type: MountTmpfs, Destination: "/run"}},
+		{spec: "/tmp:size=64m,mode=1777", want: Mount{Type: MountTmpfs, Destination: "/tmp", Options: "size=64m,mode=1777"}},
+		{spec: "/cache:ro,size=1m", want: Mount{Type: MountTmpfs, Destination: "/cache", ReadOnly: true, Options: "size=1m"}},
+		{spec: "run", wantErr: true},
+		{spec: "/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseTmpfs(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTmpfs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTmpfs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMounts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pce-mounts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		mounts  []Mount
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			mounts: []Mount{
+				{Type: MountBind, Source: dir, Destination: "/data"},
+				{Type: MountVolume, Source: "cache", Destination: "/cache"},
+				{Type: MountTmpfs, Destination: "/run"},
+			},
+		},
+		{
+			name:    "missing source",
+			mounts:  []Mount{{Type: MountBind, Source: dir + "/missing", Destination: "/data"}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate destination",
+			mounts: []Mount{
+				{Type: MountVolume, Source: "a", Destination: "/data"},
+				{Type: MountTmpfs, Destination: "/data"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMounts(tt.mounts); (err != nil) != tt.wantErr {
+				t.Errorf("validateMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
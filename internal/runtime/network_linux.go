@@ -0,0 +1,127 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Private networks are connected to the outside by slirp4netns, a user-mode
+// TCP/IP stack that works without privileges. It creates a TAP device in the
+// container's network namespace and uses slirp's default addresses.
+// https://github.com/rootless-containers/slirp4netns
+const (
+	slirpTap        = "tap0"
+	slirpMTU        = 65520
+	slirpNameserver = "10.0.2.3"
+	slirpTimeout    = 10 * time.Second
+)
+
+// slirpNetwork is a running slirp4netns process serving one container.
+type slirpNetwork struct {
+	cmd  *exec.Cmd
+	exit *os.File
+}
+
+// startSlirp connects the network namespace of pid to the outside and
+// returns once the TAP device is configured. Without slirp4netns installed
+// the container keeps a loopback-only network and nil is returned.
+func startSlirp(pid int) (*slirpNetwork, error) {
+	binary, err := exec.LookPath("slirp4netns")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: slirp4netns not found, the container only has a loopback network: %v\n", err)
+		return nil, nil
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	// slirp4netns exits once the write end of the exit pipe is closed
+	exitReader, exitWriter, err := os.Pipe()
+	if err != nil {
+		readyWriter.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(binary,
+		"--configure",
+		"--mtu="+strconv.Itoa(slirpMTU),
+		"--disable-host-loopback",
+		"--ready-fd=3",
+		"--exit-fd=4",
+		strconv.Itoa(pid), slirpTap)
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyWriter, exitReader}
+	// Keep it out of the terminal's process group, an interrupt must reach
+	// the container first and not cut off its network
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	readyWriter.Close()
+	exitReader.Close()
+	if err != nil {
+		exitWriter.Close()
+		return nil, fmt.Errorf("failed to start slirp4netns: %v", err)
+	}
+
+	readyReader.SetReadDeadline(time.Now().Add(slirpTimeout))
+	if _, err := readyReader.Read(make([]byte, 1)); err != nil {
+		exitWriter.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("slirp4netns failed to set up the network: %v", err)
+	}
+
+	return &slirpNetwork{cmd: cmd, exit: exitWriter}, nil
+}
+
+// Stop shuts slirp4netns down and waits for it to exit.
+func (s *slirpNetwork) Stop() {
+	s.exit.Close()
+	s.cmd.Wait()
+}
+
+// setupLoopback brings up the loopback interface of a new network namespace,
+// which starts out down.
+func setupLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to get loopback flags: %v", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to bring up loopback: %v", err)
+	}
+
+	return nil
+}
+
+// writeResolvConf points the container at the DNS forwarder of slirp4netns.
+// It is called after entering the container's root.
+func writeResolvConf(nameserver string) error {
+	if err := os.MkdirAll("/etc", 0755); err != nil {
+		return err
+	}
+	// The image may ship a symlink into a directory that does not exist
+	os.Remove("/etc/resolv.conf")
+	return os.WriteFile("/etc/resolv.conf", []byte("nameserver "+nameserver+"\n"), 0644)
+}
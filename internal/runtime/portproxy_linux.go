@@ -0,0 +1,413 @@
+//go:build linux
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Published ports are served by a proxy on the host. Connections into the
+// container are opened by a helper process running in the container's
+// network namespace, which hands the connected sockets back to the proxy.
+// This needs no privileges, unlike entering the namespace from the proxy.
+// Requests carry an ID the reply repeats, the helper answers them as its
+// connections complete, so a slow port does not hold up the others.
+
+// portSocketFd is the file descriptor the port helper receives requests on.
+const portSocketFd = 4
+
+// udpFlowTimeout closes UDP flows that have been idle for this long.
+const udpFlowTimeout = 60 * time.Second
+
+// portListener is a published port listening on the host.
+type portListener struct {
+	mapping PortMapping
+	tcp     net.Listener
+	udp     net.PacketConn
+}
+
+func (l *portListener) Close() error {
+	if l.tcp != nil {
+		return l.tcp.Close()
+	}
+	return l.udp.Close()
+}
+
+// listenPorts opens the host side of the published ports. This happens
+// before the container starts, so a port in use fails early.
+func listenPorts(mappings []PortMapping) ([]*portListener, error) {
+	var listeners []*portListener
+	for _, m := range mappings {
+		l := &portListener{mapping: m}
+		var err error
+		if m.Protocol == "udp" {
+			l.udp, err = net.ListenPacket("udp", m.hostAddress())
+		} else {
+			l.tcp, err = net.Listen("tcp", m.hostAddress())
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			if errors.Is(err, syscall.EADDRINUSE) {
+				return nil, fmt.Errorf("failed to publish port %s: host port %d is already in use", m, m.HostPort)
+			}
+			return nil, fmt.Errorf("failed to publish port %s: %v", m, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// portProxy relays the published ports of a running container.
+type portProxy struct {
+	listeners []*portListener
+	helper    *exec.Cmd
+	conn      *net.UnixConn
+
+	mu      sync.Mutex // guards next and pending
+	next    uint64
+	pending map[uint64]chan dialResult // nil once the helper is gone
+}
+
+// dialResult is the reply of the helper to a request.
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newPortProxy(conn *net.UnixConn) *portProxy {
+	p := &portProxy{conn: conn, pending: map[uint64]chan dialResult{}}
+	go p.readReplies()
+	return p
+}
+
+// startPortProxy starts the helper in the network namespace of pid and serves
+// the listeners.
+func startPortProxy(pid int, listeners []*portListener) (*portProxy, error) {
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return nil, fmt.Errorf("publishing ports needs nsenter from util-linux: %v", err)
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port helper socket: %v", err)
+	}
+	local, remote := os.NewFile(uintptr(fds[0]), "port-helper"), os.NewFile(uintptr(fds[1]), "port-helper")
+	defer local.Close()
+	defer remote.Close()
+
+	self, err := os.Open("/proc/self/exe")
+	if err != nil {
+		return nil, err
+	}
+	defer self.Close()
+
+	cmd := exec.Command(nsenter,
+		"--target", strconv.Itoa(pid),
+		"--user", "--net",
+		"--preserve-credentials",
+		"--", fmt.Sprintf("/proc/self/fd/%d", execFd), "internalports")
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{self, remote}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start port helper: %v", err)
+	}
+
+	conn, err := net.FileConn(local)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	p := newPortProxy(conn.(*net.UnixConn))
+	p.listeners, p.helper = listeners, cmd
+	for _, l := range listeners {
+		if l.tcp != nil {
+			go p.serveTCP(l)
+		} else {
+			go p.serveUDP(l)
+		}
+	}
+
+	return p, nil
+}
+
+// Stop closes the published ports and shuts the helper down.
+func (p *portProxy) Stop() {
+	for _, l := range p.listeners {
+		l.Close()
+	}
+	p.conn.Close()
+	p.helper.Wait()
+}
+
+// dial opens a connection to a port of the container through the helper.
+func (p *portProxy) dial(protocol string, port int) (net.Conn, error) {
+	reply := make(chan dialResult, 1)
+	p.mu.Lock()
+	if p.pending == nil {
+		p.mu.Unlock()
+		return nil, errors.New("port helper is gone")
+	}
+	p.next++
+	id := p.next
+	p.pending[id] = reply
+	p.mu.Unlock()
+
+	if _, err := p.conn.Write([]byte(fmt.Sprintf("%d %s %d", id, protocol, port))); err != nil {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return nil, fmt.Errorf("port helper is gone: %v", err)
+	}
+
+	r := <-reply
+	return r.conn, r.err
+}
+
+// readReplies hands the replies of the helper to the dials waiting for them
+// until the helper is gone.
+func (p *portProxy) readReplies() {
+	buf := make([]byte, 512)
+	oob := make([]byte, unix.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := p.conn.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 {
+			break
+		}
+
+		id, r := parseReply(buf[:n], oob[:oobn])
+		p.mu.Lock()
+		reply, ok := p.pending[id]
+		delete(p.pending, id)
+		p.mu.Unlock()
+		if !ok {
+			if r.conn != nil {
+				r.conn.Close()
+			}
+			continue
+		}
+		reply <- r
+	}
+
+	p.mu.Lock()
+	for _, reply := range p.pending {
+		reply <- dialResult{err: errors.New("port helper is gone")}
+	}
+	p.pending = nil
+	p.mu.Unlock()
+}
+
+// parseReply parses a reply of the helper, the ID of the request followed by
+// ok and the connected socket or by the error.
+func parseReply(msg, oob []byte) (uint64, dialResult) {
+	idString, text, _ := strings.Cut(string(msg), " ")
+	id, _ := strconv.ParseUint(idString, 10, 64)
+
+	var fds []int
+	if len(oob) > 0 {
+		msgs, err := unix.ParseSocketControlMessage(oob)
+		if err != nil || len(msgs) != 1 {
+			return id, dialResult{err: fmt.Errorf("invalid message from port helper: %v", err)}
+		}
+		if fds, err = unix.ParseUnixRights(&msgs[0]); err != nil || len(fds) != 1 {
+			for _, fd := range fds {
+				unix.Close(fd)
+			}
+			return id, dialResult{err: fmt.Errorf("invalid message from port helper: %v", err)}
+		}
+	}
+	if len(fds) == 0 {
+		return id, dialResult{err: errors.New(text)}
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "container-conn")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	return id, dialResult{conn: conn, err: err}
+}
+
+func (p *portProxy) serveTCP(l *portListener) {
+	for {
+		client, err := l.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer client.Close()
+			conn, err := p.dial("tcp", l.mapping.ContainerPort)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to connect to port %d of the container: %v\n", l.mapping.ContainerPort, err)
+				return
+			}
+			defer conn.Close()
+			relay(client, conn)
+		}()
+	}
+}
+
+// relay copies between both connections until both directions are done.
+func relay(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// Pass the end of the stream on, the other direction may continue
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			conn.CloseWrite()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
+
+func (p *portProxy) serveUDP(l *portListener) {
+	var mu sync.Mutex
+	flows := map[string]net.Conn{}
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			mu.Lock()
+			for _, flow := range flows {
+				flow.Close()
+			}
+			mu.Unlock()
+			return
+		}
+
+		mu.Lock()
+		flow, ok := flows[addr.String()]
+		if !ok {
+			flow, err = p.dial("udp", l.mapping.ContainerPort)
+			if err != nil {
+				mu.Unlock()
+				fmt.Fprintf(os.Stderr, "Warning: failed to connect to port %d of the container: %v\n", l.mapping.ContainerPort, err)
+				continue
+			}
+			flows[addr.String()] = flow
+
+			// Relay the replies of the container back to the client
+			go func() {
+				reply := make([]byte, 65535)
+				for {
+					flow.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+					n, err := flow.Read(reply)
+					if err != nil {
+						break
+					}
+					l.udp.WriteTo(reply[:n], addr)
+				}
+				mu.Lock()
+				delete(flows, addr.String())
+				mu.Unlock()
+				flow.Close()
+			}()
+		}
+		mu.Unlock()
+
+		// Traffic in either direction keeps the flow open
+		flow.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+		flow.Write(buf[:n])
+	}
+}
+
+func (r *platformRuntime) ServePorts() error {
+	f := os.NewFile(portSocketFd, "port-helper")
+	c, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	conn := c.(*net.UnixConn)
+	defer conn.Close()
+
+	return servePortRequests(conn, dialContainerPort)
+}
+
+// dialContainerPort connects to a port of the container from inside its
+// network namespace. TCP tries loopback, then IPv6 loopback for services
+// listening only on IPv6 and then the address of the TAP device for services
+// bound to it alone. UDP cannot tell whether a port is open and uses loopback.
+func dialContainerPort(protocol, port string) (net.Conn, error) {
+	hosts := []string{"127.0.0.1", "::1"}
+	if protocol == "tcp" {
+		if tap, err := net.InterfaceByName(slirpTap); err == nil {
+			addrs, _ := tap.Addrs()
+			for _, addr := range addrs {
+				if ip, ok := addr.(*net.IPNet); ok && ip.IP.To4() != nil {
+					hosts = append(hosts, ip.IP.String())
+				}
+			}
+		}
+	}
+
+	var firstErr error
+	for _, host := range hosts {
+		conn, err := net.Dial(protocol, net.JoinHostPort(host, port))
+		if err == nil || protocol != "tcp" {
+			return conn, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// servePortRequests answers the requests of the proxy on conn with
+// connections opened by dial, each as soon as it is connected.
+func servePortRequests(conn *net.UnixConn, dial func(protocol, port string) (net.Conn, error)) error {
+	buf := make([]byte, 64)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil || n == 0 {
+			// The proxy is gone, dials still connecting are abandoned
+			return nil
+		}
+
+		var id, protocol, port string
+		if fields := strings.Fields(string(buf[:n])); len(fields) == 3 {
+			id, protocol, port = fields[0], fields[1], fields[2]
+		}
+
+		go func() {
+			target, err := dial(protocol, port)
+			if err != nil {
+				conn.Write([]byte(id + " " + err.Error()))
+				return
+			}
+
+			file, err := target.(interface{ File() (*os.File, error) }).File()
+			target.Close()
+			if err != nil {
+				conn.Write([]byte(id + " " + err.Error()))
+				return
+			}
+			defer file.Close()
+			if _, _, err := conn.WriteMsgUnix([]byte(id+" ok"), unix.UnixRights(int(file.Fd())), nil); err != nil {
+				// Ends the loop, the proxy cannot be reached anymore
+				conn.Close()
+			}
+		}()
+	}
+}
@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPortProxySlowPort(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("failed to create socket pair: %v", err)
+	}
+	var conns [2]*net.UnixConn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "port-helper")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to create connection: %v", err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// Port 1 never connects, like a container port that does not answer
+	slow := make(chan struct{})
+	defer close(slow)
+	go servePortRequests(conns[1], func(protocol, port string) (net.Conn, error) {
+		if port == "1" {
+			<-slow
+			return nil, os.ErrDeadlineExceeded
+		}
+		return dialContainerPort(protocol, port)
+	})
+
+	p := newPortProxy(conns[0])
+	defer conns[0].Close()
+	go p.dial("tcp", 1)
+
+	done := make(chan error, 1)
+	go func() {
+		port := l.Addr().(*net.TCPAddr).Port
+		conn, err := p.dial("tcp", port)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("dial() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial() waited for the connection to another port")
+	}
+
+	// Errors reach the dial they belong to
+	if _, err := p.dial("tcp", -1); err == nil {
+		t.Error("dial() of an invalid port succeeded")
+	}
+}
+
+func TestDialContainerPortIPv6(t *testing.T) {
+	// A service listening only on IPv6 is reached through IPv6 loopback
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	conn, err := dialContainerPort("tcp", port)
+	if err != nil {
+		t.Fatalf("dialContainerPort() error = %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortMapping publishes a port of a container on the host.
+type PortMapping struct {
+	HostIP        string `json:"hostIP,omitempty"`
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+}
+
+// ParsePortMapping parses a port mapping given as
+// [hostIP:]hostPort:containerPort[/tcp|/udp].
+func ParsePortMapping(spec string) (PortMapping, error) {
+	m := PortMapping{Protocol: "tcp"}
+
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, m.Protocol = spec[:i], strings.ToLower(spec[i+1:])
+		if m.Protocol != "tcp" && m.Protocol != "udp" {
+			return m, fmt.Errorf("invalid protocol %q in port mapping %q, use tcp or udp", m.Protocol, spec)
+		}
+	}
+
+	i := strings.LastIndex(ports, ":")
+	if i < 0 {
+		return m, fmt.Errorf("invalid port mapping %q, expected [ip:]hostPort:containerPort", spec)
+	}
+	host, containerPort := ports[:i], ports[i+1:]
+	if j := strings.LastIndex(host, ":"); j >= 0 {
+		m.HostIP = strings.TrimSuffix(strings.TrimPrefix(host[:j], "["), "]")
+		host = host[j+1:]
+		if net.ParseIP(m.HostIP) == nil {
+			return m, fmt.Errorf("invalid IP address %q in port mapping %q", m.HostIP, spec)
+		}
+	}
+
+	var err error
+	if m.HostPort, err = parsePort(host); err != nil {
+		return m, fmt.Errorf("invalid host port in port mapping %q: %v", spec, err)
+	}
+	if m.ContainerPort, err = parsePort(containerPort); err != nil {
+		return m, fmt.Errorf("invalid container port in port mapping %q: %v", spec, err)
+	}
+
+	return m, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%q is not a port between 1 and 65535", s)
+	}
+	return port, nil
+}
+
+func (m PortMapping) hostAddress() string {
+	return net.JoinHostPort(m.HostIP, strconv.Itoa(m.HostPort))
+}
+
+func (m PortMapping) String() string {
+	ip := m.HostIP
+	if ip == "" {
+		ip = "0.0.0.0"
+	}
+	return fmt.Sprintf("%s->%d/%s", net.JoinHostPort(ip, strconv.Itoa(m.HostPort)), m.ContainerPort, m.Protocol)
+}
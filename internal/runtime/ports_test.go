@@ -0,0 +1,52 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    PortMapping
+		wantErr bool
+	}{
+		{
+			name: "host and container port",
+			spec: "8080:80",
+			want: PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		},
+		{
+			name: "udp",
+			spec: "5353:53/udp",
+			want: PortMapping{HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+		},
+		{
+			name: "host IP",
+			spec: "127.0.0.1:8080:80/tcp",
+			want: PortMapping{HostIP: "127.0.0.1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		},
+		{
+			name: "IPv6 host IP",
+			spec: "[::1]:8080:80",
+			want: PortMapping{HostIP: "::1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		},
+		{name: "container port only", spec: "80", wantErr: true},
+		{name: "unknown protocol", spec: "8080:80/sctp", wantErr: true},
+		{name: "port out of range", spec: "70000:80", wantErr: true},
+		{name: "invalid port", spec: "8080:http", wantErr: true},
+		{name: "invalid IP", spec: "localhost:8080:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePortMapping(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortMapping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParsePortMapping() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountProc mounts /proc and a read-only /sys in rootfs. A user namespace
+// can only mount them while the ones of the host are visible, so before the
+// root switch.
+func mountProc(rootfs string) error {
+	proc := filepath.Join(rootfs, "proc")
+	if err := os.MkdirAll(proc, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %v", err)
+	}
+	if err := mountSys(filepath.Join(rootfs, "sys")); err != nil {
+		return fmt.Errorf("failed to mount /sys: %v", err)
+	}
+	return nil
+}
+
+// protectPaths hides the masked paths and makes the read-only ones
+// read-only. It runs after the root switch, so symlinks in the root
+// filesystem cannot lead to paths of the host.
+func protectPaths(masked, readonly []string) error {
+	for _, p := range masked {
+		if err := maskPath(p); err != nil {
+			return fmt.Errorf("failed to mask %s: %v", p, err)
+		}
+	}
+	for _, p := range readonly {
+		if err := readonlyPath(p); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// mountSys mounts a read-only sysfs at sys. sysfs shows the network devices
+// of the network namespace it is mounted from, which must belong to the user
+// namespace of the container. With the network of the host the /sys of the
+// host is bound read-only instead.
+func mountSys(sys string) error {
+	if err := os.MkdirAll(sys, 0755); err != nil {
+		return err
+	}
+	err := syscall.Mount("sysfs", sys, "sysfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != syscall.EPERM {
+		return err
+	}
+
+	if err := syscall.Mount("/sys", sys, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	return setReadOnly(sys, unix.AT_RECURSIVE)
+}
+
+// maskPath hides the file or directory at path, if it exists.
+func maskPath(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "size=0")
+	}
+	return syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+}
+
+// readonlyPath binds path onto itself read-only, if it exists.
+func readonlyPath(path string) error {
+	err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return setReadOnly(path, unix.AT_RECURSIVE)
+}
+
+// setReadOnly makes the mount at path read-only, with flags like
+// AT_RECURSIVE for the mounts below it. Unlike a remount, mount_setattr keeps
+// the flags the kernel locked when the mount was copied into the user
+// namespace.
+func setReadOnly(path string, flags uint) error {
+	attr := &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	return unix.MountSetattr(unix.AT_FDCWD, path, flags, attr)
+}
+
+// readonlyRootfs makes the root filesystem of the container read-only once
+// it was entered. Volumes, /dev and the other mounts below it stay writable.
+func readonlyRootfs() error {
+	if err := setReadOnly("/", 0); err != nil {
+		return fmt.Errorf("failed to make root filesystem read-only: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cgroupPeriod is the CPU period in microseconds the --cpus quota applies to.
+const cgroupPeriod = 100000
+
+// Resources limits what a container may use. Zero values mean no limit.
+type Resources struct {
+	Memory    int64   `json:"memory,omitempty"` // bytes
+	CPUs      float64 `json:"cpus,omitempty"`
+	PidsLimit int64   `json:"pidsLimit,omitempty"`
+	CPUShares int64   `json:"cpuShares,omitempty"`
+}
+
+// validate checks the limits for values the kernel would reject.
+func (r Resources) validate() error {
+	switch {
+	case r.Memory < 0:
+		return fmt.Errorf("memory limit must not be negative")
+	case r.CPUs < 0:
+		return fmt.Errorf("number of CPUs must not be negative")
+	case r.CPUs > 0 && r.CPUs*cgroupPeriod < 1000:
+		return fmt.Errorf("number of CPUs must be at least 0.01")
+	case r.PidsLimit < 0:
+		return fmt.Errorf("pids limit must not be negative")
+	case r.CPUShares != 0 && r.CPUShares < 2, r.CPUShares > 262144:
+		return fmt.Errorf("CPU shares must be between 2 and 262144")
+	}
+	return nil
+}
+
+// cgroupFiles returns the cgroup v2 interface files and values for the limits.
+func (r Resources) cgroupFiles() map[string]string {
+	files := map[string]string{}
+	if r.Memory > 0 {
+		files["memory.max"] = strconv.FormatInt(r.Memory, 10)
+	}
+	if r.CPUs > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(r.CPUs*cgroupPeriod), cgroupPeriod)
+	}
+	if r.PidsLimit > 0 {
+		files["pids.max"] = strconv.FormatInt(r.PidsLimit, 10)
+	}
+	if r.CPUShares > 0 {
+		files["cpu.weight"] = strconv.FormatInt(cpuWeight(r.CPUShares), 10)
+	}
+	return files
+}
+
+// controllers returns the cgroup controllers the limits need.
+func (r Resources) controllers() []string {
+	seen := map[string]bool{}
+	var controllers []string
+	for file := range r.cgroupFiles() {
+		controller := strings.SplitN(file, ".", 2)[0]
+		if !seen[controller] {
+			seen[controller] = true
+			controllers = append(controllers, controller)
+		}
+	}
+	return controllers
+}
+
+// cpuWeight converts cgroup v1 CPU shares (2-262144, default 1024) to a
+// cgroup v2 CPU weight (1-10000, default 100), the same way as other runtimes.
+func cpuWeight(shares int64) int64 {
+	if shares < 2 {
+		shares = 2
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// ParseBytes parses a size like 512m or 2g into bytes. The suffixes b, k, m
+// and g stand for powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "b")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional b, k, m or g suffix", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "100b", want: 100},
+		{input: "4k", want: 4096},
+		{input: "512m", want: 512 << 20},
+		{input: "2G", want: 2 << 30},
+		{input: "1gb", want: 1 << 30},
+		{input: "", wantErr: true},
+		{input: "m", wantErr: true},
+		{input: "1.5g", wantErr: true},
+		{input: "-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseBytes(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBytes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseBytes() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResources(t *testing.T) {
+	tests := []struct {
+		name            string
+		resources       Resources
+		wantFiles       map[string]string
+		wantControllers []string
+		wantErr         bool
+	}{
+		{
+			name:      "no limits",
+			resources: Resources{},
+			wantFiles: map[string]string{},
+		},
+		{
+			name:            "all limits",
+			resources:       Resources{Memory: 512 << 20, CPUs: 1.5, PidsLimit: 100, CPUShares: 512},
+			wantFiles:       map[string]string{"memory.max": "536870912", "cpu.max": "150000 100000", "pids.max": "100", "cpu.weight": "20"},
+			wantControllers: []string{"cpu", "memory", "pids"},
+		},
+		{
+			name:            "default shares",
+			resources:       Resources{CPUShares: 1024},
+			wantFiles:       map[string]string{"cpu.weight": "39"},
+			wantControllers: []string{"cpu"},
+		},
+		{name: "negative memory", resources: Resources{Memory: -1}, wantErr: true},
+		{name: "too few CPUs", resources: Resources{CPUs: 0.001}, wantErr: true},
+		{name: "too few shares", resources: Resources{CPUShares: 1}, wantErr: true},
+		{name: "negative shares", resources: Resources{CPUShares: -1}, wantErr: true},
+		{name: "too many shares", resources: Resources{CPUShares: 300000}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.resources.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := tt.resources.cgroupFiles(); !reflect.DeepEqual(got, tt.wantFiles) {
+				t.Errorf("cgroupFiles() = %v, want %v", got, tt.wantFiles)
+			}
+			got := tt.resources.controllers()
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantControllers) {
+				t.Errorf("controllers() = %v, want %v", got, tt.wantControllers)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	img "github.com/troppes/portable-container-engine/internal/image"
+	"golang.org/x/sys/unix"
+)
+
+// mountRootfs assembles the container's root filesystem at rootfs. The cached
+// layers are stacked read-only below the container's upper directory with
+// overlayfs. When unprivileged overlay mounts are not available it falls back
+// to fuse-overlayfs and finally to copying the layers into rootfs.
+func mountRootfs(rootfs string, config *initConfig) error {
+	// The root filesystem of a bundle is used in place
+	if config.Rootfs != "" {
+		return syscall.Mount(config.Rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, "")
+	}
+
+	if img.OverlayCompatible(config.Layers) {
+		err := mountOverlay(rootfs, config)
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: overlay mount failed, trying fuse-overlayfs: %v\n", err)
+
+		err = mountFuseOverlay(rootfs, config)
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: fuse-overlayfs mount failed, copying layers instead: %v\n", err)
+	}
+
+	// A copied root filesystem is the container's writable layer, restarts
+	// must not overwrite the changes made to it
+	if entries, err := os.ReadDir(rootfs); err == nil && len(entries) > 0 {
+		return nil
+	}
+
+	return img.CopyLayers(config.Layers, rootfs)
+}
+
+func mountOverlay(rootfs string, config *initConfig) error {
+	// Mount options are limited to a page, so the layers are given relative
+	// to the layer cache to fit images with many layers
+	dir, lowerDirs := relativeLayers(config.Layers)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(cwd)
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+		strings.Join(lowerDirs, ":"), config.UpperDir, config.WorkDir)
+
+	return syscall.Mount("overlay", rootfs, "overlay", 0, options)
+}
+
+func mountFuseOverlay(rootfs string, config *initConfig) error {
+	binary, err := exec.LookPath("fuse-overlayfs")
+	if err != nil {
+		return err
+	}
+
+	lowerDirs := make([]string, len(config.Layers))
+	for i, layer := range config.Layers {
+		lowerDirs[len(config.Layers)-1-i] = layer
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowerDirs, ":"), config.UpperDir, config.WorkDir)
+
+	output, err := exec.Command(binary, "-o", options, rootfs).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// relativeLayers returns the directory containing all layers and the layer
+// paths relative to it, topmost layer first as overlayfs expects them.
+func relativeLayers(layers []string) (string, []string) {
+	dir := "/"
+	if len(layers) > 0 {
+		// <cache>/<diffid>/<mapping>/rootfs
+		dir = filepath.Dir(filepath.Dir(filepath.Dir(layers[0])))
+	}
+
+	lowerDirs := make([]string, len(layers))
+	for i, layer := range layers {
+		rel, err := filepath.Rel(dir, layer)
+		if err != nil {
+			rel = layer
+		}
+		lowerDirs[len(layers)-1-i] = rel
+	}
+
+	return dir, lowerDirs
+}
+
+// enterRootfs makes rootfs the root of the container. pivot_root swaps the
+// root of the mount namespace and the old root is detached, so the host
+// filesystem is gone from the container's view. chroot only changes the root
+// directory of the process and is kept for filesystems pivot_root does not
+// support, like an initramfs.
+func enterRootfs(rootfs string, noPivot bool) error {
+	// Mounts must not propagate back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+	// pivot_root needs the new root to be a mount point. With chroot it
+	// keeps a copied root filesystem from being part of the mount of the
+	// host, which --read-only would make read-only instead.
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount rootfs: %v", err)
+	}
+	if err := os.Chdir(rootfs); err != nil {
+		return err
+	}
+
+	if noPivot {
+		if err := syscall.Chroot("."); err != nil {
+			return err
+		}
+		return os.Chdir("/")
+	}
+
+	// Stack the old root on top of the new one and detach it right away,
+	// which needs no directory for it inside the container
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root failed, use --no-pivot on filesystems that do not support it: %v", err)
+	}
+	if err := syscall.Mount("", ".", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %v", err)
+	}
+
+	return os.Chdir("/")
+}
+
+// joinRootfs moves the calling thread into the mount namespace and root
+// directory of the process at proc, e.g. /proc/<pid>. Joining a mount
+// namespace is only possible for a thread that does not share its
+// filesystem attributes, so the thread is locked and the caller has to exec
+// right after.
+func joinRootfs(proc string) error {
+	root, err := os.Open(filepath.Join(proc, "root"))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	ns, err := os.Open(filepath.Join(proc, "ns", "mnt"))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return fmt.Errorf("failed to unshare filesystem attributes: %v", err)
+	}
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to join mount namespace: %v", err)
+	}
+	if err := unix.Fchdir(int(root.Fd())); err != nil {
+		return err
+	}
+	if err := unix.Chroot("."); err != nil {
+		return err
+	}
+	return unix.Chdir("/")
+}
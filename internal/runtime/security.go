@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+)
+
+// SeccompUnconfined is the seccomp profile running a container without a
+// seccomp filter.
+const SeccompUnconfined = "unconfined"
+
+// ParseSecurityOpt applies an option given with --security-opt to opts:
+// seccomp=profile.json loads a seccomp profile in the JSON format of Docker
+// and the OCI runtime spec, seccomp=unconfined disables seccomp and
+// no-new-privileges[=true|false] sets no_new_privs.
+func ParseSecurityOpt(spec string, opts *RunOptions) error {
+	key, value, hasValue := strings.Cut(spec, "=")
+	switch key {
+	case "seccomp":
+		if value == "" {
+			return fmt.Errorf("invalid security option %q, expected seccomp=profile.json or seccomp=%s", spec, SeccompUnconfined)
+		}
+		if value == SeccompUnconfined {
+			opts.Seccomp, opts.SeccompUnconfined = nil, true
+			return nil
+		}
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return fmt.Errorf("failed to read seccomp profile: %v", err)
+		}
+		p, err := seccomp.ParseProfile(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", value, err)
+		}
+		opts.Seccomp, opts.SeccompUnconfined = p, false
+		return nil
+	case "no-new-privileges":
+		enabled := true
+		if hasValue {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid security option %q, expected no-new-privileges=true or false", spec)
+			}
+		}
+		opts.NewPrivileges = !enabled
+		return nil
+	default:
+		return fmt.Errorf("unknown security option %q", spec)
+	}
+}
+
+// seccompProfile returns the seccomp profile of the container, nil if it runs
+// unconfined.
+func (o RunOptions) seccompProfile() *seccomp.Profile {
+	switch {
+	case o.SeccompUnconfined:
+		return nil
+	case o.Seccomp != nil:
+		return o.Seccomp
+	default:
+		return seccomp.DefaultProfile()
+	}
+}
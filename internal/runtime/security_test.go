@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+)
+
+func TestParseSecurityOpt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pce-security-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := filepath.Join(dir, "profile.json")
+	data := `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mount"], "action": "SCMP_ACT_ERRNO"}]}`
+	if err := os.WriteFile(profile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"defaultAction": "SCMP_ACT_NONE"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec           string
+		wantProfile    bool
+		wantUnconfined bool
+		wantNewPrivs   bool
+		wantErr        bool
+	}{
+		{spec: "seccomp=" + profile, wantProfile: true},
+		{spec: "seccomp=unconfined", wantUnconfined: true},
+		{spec: "no-new-privileges"},
+		{spec: "no-new-privileges=true"},
+		{spec: "no-new-privileges=false", wantNewPrivs: true},
+		{spec: "no-new-privileges=maybe", wantErr: true},
+		{spec: "seccomp=", wantErr: true},
+		{spec: "seccomp=" + invalid, wantErr: true},
+		{spec: "seccomp=" + filepath.Join(dir, "missing.json"), wantErr: true},
+		{spec: "apparmor=unconfined", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			var opts RunOptions
+			err := ParseSecurityOpt(tt.spec, &opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSecurityOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (opts.Seccomp != nil) != tt.wantProfile {
+				t.Errorf("Seccomp = %v, want a profile %v", opts.Seccomp, tt.wantProfile)
+			}
+			if opts.SeccompUnconfined != tt.wantUnconfined {
+				t.Errorf("SeccompUnconfined = %v, want %v", opts.SeccompUnconfined, tt.wantUnconfined)
+			}
+			if opts.NewPrivileges != tt.wantNewPrivs {
+				t.Errorf("NewPrivileges = %v, want %v", opts.NewPrivileges, tt.wantNewPrivs)
+			}
+		})
+	}
+}
+
+func TestSeccompProfile(t *testing.T) {
+	custom := &seccomp.Profile{DefaultAction: "SCMP_ACT_LOG"}
+	if p := (RunOptions{}).seccompProfile(); p == nil || p.DefaultAction != "SCMP_ACT_ERRNO" {
+		t.Errorf("seccompProfile() without options = %v, want the default profile", p)
+	}
+	if p := (RunOptions{Seccomp: custom}).seccompProfile(); p != custom {
+		t.Errorf("seccompProfile() = %v, want the custom profile", p)
+	}
+	if p := (RunOptions{SeccompUnconfined: true}).seccompProfile(); p != nil {
+		t.Errorf("seccompProfile() unconfined = %v, want nil", p)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// parseSignal parses a signal given by name, with or without the SIG
+// prefix, or by number.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > 64 {
+			return 0, fmt.Errorf("invalid signal %q", s)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("invalid signal %q", s)
+}
+
+// stopSignal returns the signal the container is stopped with, given on the
+// command line or by its image, SIGTERM by default.
+func (c *Container) stopSignal() syscall.Signal {
+	for _, name := range []string{c.Options.StopSignal, c.ImageConfig.StopSignal} {
+		if name == "" {
+			continue
+		}
+		sig, err := parseSignal(name)
+		if err == nil {
+			return sig
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v, stopping container with SIGTERM\n", err)
+		break
+	}
+	return syscall.SIGTERM
+}
+
+// stopProcess sends the stop signal to the init process of a container and
+// kills it if it did not exit within timeout. exited reports whether the
+// process is gone.
+func stopProcess(pid int, sig syscall.Signal, timeout time.Duration, exited func() bool) error {
+	if err := syscall.Kill(pid, sig); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return fmt.Errorf("failed to send %s: %v", unix.SignalName(sig), err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if exited() {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if exited() {
+		return nil
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to kill process: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package runtime
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	img "github.com/troppes/portable-container-engine/internal/image"
+	"github.com/troppes/portable-container-engine/internal/spec"
+	util "github.com/troppes/portable-container-engine/internal/util"
+)
+
+// Container states
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+)
+
+var ErrContainerNotFound = errors.New("no such container")
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Container is a container with its own state directory under
+// <data root>/containers/<id>. Its configuration is fixed at creation and
+// stored in config.json, while the runtime state lives in state.json.
+type Container struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Image       string     `json:"image"`
+	Command     []string   `json:"command"`
+	Options     RunOptions `json:"options"`
+	Layers      []string   `json:"layers"`
+	ImageConfig v1.Config  `json:"imageConfig"`
+	// Spec is the config of the bundle the container runs instead of an
+	// image.
+	Spec    *spec.Spec `json:"spec,omitempty"`
+	Created time.Time  `json:"created"`
+
+	State State `json:"-"`
+}
+
+// State is the runtime state of a container.
+type State struct {
+	Status     string    `json:"status"`
+	Pid        int       `json:"pid,omitempty"`
+	Cgroup     string    `json:"cgroup,omitempty"`
+	ExitCode   int       `json:"exitCode"`
+	StartedAt  time.Time `json:"startedAt,omitzero"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+}
+
+func containersDir() string {
+	return filepath.Join(util.DataRoot(), "containers")
+}
+
+// Dir returns the state directory of the container.
+func (c *Container) Dir() string {
+	return filepath.Join(containersDir(), c.ID)
+}
+
+func (c *Container) RootfsDir() string {
+	return filepath.Join(c.Dir(), "rootfs")
+}
+
+func (c *Container) UpperDir() string {
+	return filepath.Join(c.Dir(), "upper")
+}
+
+func (c *Container) WorkDir() string {
+	return filepath.Join(c.Dir(), "work")
+}
+
+// newContainer allocates an ID and the state directory for a new container.
+func newContainer(image string, command []string, opts RunOptions) (*Container, error) {
+	id, err := newContainerID()
+	if err != nil {
+		return nil, err
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = id[:12]
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if _, err := findContainer(name); err == nil {
+		return nil, fmt.Errorf("container name %q is already in use", name)
+	}
+
+	c := &Container{
+		ID:      id,
+		Name:    name,
+		Image:   image,
+		Command: command,
+		Options: opts,
+		Created: time.Now(),
+		State:   State{Status: StatusCreated},
+	}
+
+	for _, dir := range []string{c.Dir(), c.RootfsDir(), c.UpperDir(), c.WorkDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create container directory: %v", err)
+		}
+	}
+	// The state directory keeps other users of the host out, while the root
+	// of the container must be accessible to its non-root users
+	for _, dir := range []string{c.RootfsDir(), c.UpperDir()} {
+		if err := os.Chmod(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create container directory: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+func newContainerID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// save writes the container configuration and state.
+func (c *Container) save() error {
+	if err := writeJSON(filepath.Join(c.Dir(), "config.json"), c); err != nil {
+		return err
+	}
+	return c.saveState()
+}
+
+func (c *Container) saveState() error {
+	return writeJSON(filepath.Join(c.Dir(), "state.json"), &c.State)
+}
+
+// writeJSON replaces path atomically, so readers never see a partial file.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadContainer(id string) (*Container, error) {
+	dir := filepath.Join(containersDir(), id)
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var c Container
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid config of container %s: %v", id, err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "state.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.State); err != nil {
+		return nil, fmt.Errorf("invalid state of container %s: %v", id, err)
+	}
+
+	return &c, nil
+}
+
+// listContainers returns all containers, newest first.
+func listContainers() ([]*Container, error) {
+	entries, err := os.ReadDir(containersDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []*Container
+	for _, entry := range entries {
+		c, err := loadContainer(entry.Name())
+		if err != nil {
+			// Containers still being created have no state yet
+			continue
+		}
+		containers = append(containers, c)
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Created.After(containers[j].Created)
+	})
+
+	return containers, nil
+}
+
+// findContainer looks a container up by name, full ID or unique ID prefix.
+func findContainer(ref string) (*Container, error) {
+	// Every ID starts with the empty prefix
+	if ref == "" {
+		return nil, fmt.Errorf("%w: empty name or ID", ErrContainerNotFound)
+	}
+
+	containers, err := listContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Container
+	for _, c := range containers {
+		if c.Name == ref || c.ID == ref {
+			return c, nil
+		}
+		if strings.HasPrefix(c.ID, ref) {
+			matches = append(matches, c)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("multiple containers match %q, use a longer ID", ref)
+	}
+}
+
+// removeContainerDir deletes the state directory of the container and
+// releases the layers it uses.
+func removeContainerDir(c *Container) error {
+	if err := img.ReleaseLayers(c.Layers, c.ID); err != nil {
+		return fmt.Errorf("failed to release layers: %v", err)
+	}
+	// overlayfs leaves an inaccessible directory in its workdir and copied
+	// root filesystems keep the read-only directories of the image
+	return img.RemoveAll(c.Dir())
+}
+
+// StatusString describes the state of the container the way `pce ps` shows it.
+func (c *Container) StatusString() string {
+	switch c.State.Status {
+	case StatusRunning:
+		return "Up " + humanDuration(time.Since(c.State.StartedAt))
+	case StatusExited:
+		return fmt.Sprintf("Exited (%d) %s ago", c.State.ExitCode, humanDuration(time.Since(c.State.FinishedAt)))
+	default:
+		return "Created"
+	}
+}
+
+// ImageString names what the container runs, its image or its bundle.
+func (c *Container) ImageString() string {
+	if c.Spec != nil {
+		return c.Options.Bundle
+	}
+	return c.Image
+}
+
+// CreatedString describes how long ago the container was created.
+func (c *Container) CreatedString() string {
+	return humanDuration(time.Since(c.Created)) + " ago"
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestContainerState(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pce-state-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Setenv("PCE_ROOT", tmpDir)
+
+	web, err := newContainer("alpine:latest", []string{"/bin/sh"}, RunOptions{Name: "web"})
+	if err != nil {
+		t.Fatalf("newContainer() error = %v", err)
+	}
+	if err := web.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	unnamed, err := newContainer("alpine:latest", []string{"true"}, RunOptions{})
+	if err != nil {
+		t.Fatalf("newContainer() error = %v", err)
+	}
+	unnamed.Created = web.Created.Add(time.Second)
+	unnamed.State = State{Status: StatusExited, ExitCode: 3, FinishedAt: time.Now()}
+	if err := unnamed.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	if unnamed.Name != unnamed.ID[:12] {
+		t.Errorf("default name = %q, want %q", unnamed.Name, unnamed.ID[:12])
+	}
+
+	if _, err := newContainer("alpine:latest", nil, RunOptions{Name: "web"}); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	if _, err := newContainer("alpine:latest", nil, RunOptions{Name: "-bad/name"}); err == nil {
+		t.Error("expected error for invalid name")
+	}
+
+	containers, err := listContainers()
+	if err != nil {
+		t.Fatalf("listContainers() error = %v", err)
+	}
+	if len(containers) != 2 || containers[0].ID != unnamed.ID || containers[1].ID != web.ID {
+		t.Fatalf("listContainers() returned wrong containers or order")
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantID  string
+		wantErr bool
+	}{
+		{name: "by name", ref: "web", wantID: web.ID},
+		{name: "by full ID", ref: unnamed.ID, wantID: unnamed.ID},
+		{name: "by ID prefix", ref: web.ID[:8], wantID: web.ID},
+		{name: "unknown", ref: "missing", wantErr: true},
+		{name: "empty", ref: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := findContainer(tt.ref)
+			if tt.wantErr {
+				if !errors.Is(err, ErrContainerNotFound) {
+					t.Errorf("findContainer() error = %v, want ErrContainerNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findContainer() error = %v", err)
+			}
+			if c.ID != tt.wantID {
+				t.Errorf("findContainer() = %s, want %s", c.ID, tt.wantID)
+			}
+		})
+	}
+
+	c, err := findContainer("web")
+	if err != nil {
+		t.Fatalf("findContainer() error = %v", err)
+	}
+	if c.Image != "alpine:latest" || len(c.Command) != 1 || c.State.Status != StatusCreated {
+		t.Errorf("loaded container does not match saved one: %+v", c)
+	}
+	if got := c.StatusString(); got != "Created" {
+		t.Errorf("StatusString() = %q, want Created", got)
+	}
+
+	exited, err := findContainer(unnamed.Name)
+	if err != nil {
+		t.Fatalf("findContainer() error = %v", err)
+	}
+	if got := exited.StatusString(); got != "Exited (3) 0 seconds ago" {
+		t.Errorf("StatusString() = %q, want %q", got, "Exited (3) 0 seconds ago")
+	}
+
+	if err := removeContainerDir(web); err != nil {
+		t.Fatalf("removeContainerDir() error = %v", err)
+	}
+	if _, err := findContainer("web"); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("container still found after removal: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// enterTimeNamespace creates a time namespace in which the monotonic and
+// boot time clocks start at zero, as if the container just booted. The
+// process only enters it with its next execve.
+//
+// The offsets of a time namespace can only be set before a process entered
+// it, which rules out creating it when cloning the container. Unsharing it
+// affects only the current thread, so the thread stays locked until the
+// command of the container is executed from it.
+func enterTimeNamespace() error {
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWTIME); err != nil {
+		return fmt.Errorf("failed to create time namespace: %v", err)
+	}
+
+	var monotonic, boottime unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &monotonic); err != nil {
+		return err
+	}
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &boottime); err != nil {
+		return err
+	}
+	offsets := fmt.Sprintf("monotonic %d 0\nboottime %d 0\n", -monotonic.Sec, -boottime.Sec)
+
+	// The offsets belong to the thread, which has its own entry in the
+	// /proc of the container
+	path := fmt.Sprintf("/proc/%d/timens_offsets", unix.Gettid())
+	if err := os.WriteFile(path, []byte(offsets), 0644); err != nil {
+		return fmt.Errorf("failed to set time namespace offsets: %v", err)
+	}
+	return nil
+}
+
+// timeNamespacePath returns the time namespace of the container whose init
+// process is pid. Only the thread that created it has the namespace for its
+// children, so with --init, whose process never executes the command, the
+// namespace is found through that thread instead of the process.
+func timeNamespacePath(pid int) string {
+	path := fmt.Sprintf("/proc/%d/ns/time", pid)
+	var own unix.Stat_t
+	if err := unix.Stat(path, &own); err != nil {
+		return path
+	}
+	tasks, _ := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	for _, task := range tasks {
+		children := fmt.Sprintf("/proc/%d/task/%s/ns/time_for_children", pid, task.Name())
+		var ns unix.Stat_t
+		if err := unix.Stat(children, &ns); err == nil && (ns.Ino != own.Ino || ns.Dev != own.Dev) {
+			return children
+		}
+	}
+	return path
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTimeNamespacePath(t *testing.T) {
+	pid := os.Getpid()
+	if got, want := timeNamespacePath(pid), fmt.Sprintf("/proc/%d/ns/time", pid); got != want {
+		t.Errorf("timeNamespacePath() without a time namespace = %s, want %s", got, want)
+	}
+
+	// Like the init of a container with --init and --timens, a thread creates
+	// the time namespace and starts the command in it without executing
+	type started struct {
+		cmd *exec.Cmd
+		err error
+	}
+	result := make(chan started)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// The thread stays locked and exits with the goroutine
+		if err := enterTimeNamespace(); err != nil {
+			result <- started{err: err}
+			return
+		}
+		cmd := exec.Command("sleep", "60")
+		err := cmd.Start()
+		result <- started{cmd, err}
+		<-done
+	}()
+	r := <-result
+	if r.err != nil {
+		t.Skipf("cannot start a process in a time namespace: %v", r.err)
+	}
+	defer func() {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+	}()
+
+	path := timeNamespacePath(pid)
+	var got, want unix.Stat_t
+	if err := unix.Stat(path, &got); err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	if err := unix.Stat(fmt.Sprintf("/proc/%d/ns/time", r.cmd.Process.Pid), &want); err != nil {
+		t.Fatalf("failed to stat time namespace of the command: %v", err)
+	}
+	if got.Ino != want.Ino || got.Dev != want.Dev {
+		t.Errorf("timeNamespacePath() = %s, not the time namespace of the command", path)
+	}
+}
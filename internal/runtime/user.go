@@ -0,0 +1,114 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// execUser is the user a container process runs as.
+type execUser struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // supplementary groups
+	Home   string
+}
+
+// resolveUser resolves the User of an image or a container, given as
+// user[:group] with names or numeric IDs, against the passwd and group
+// files of the container. Either file may be nil if it does not exist,
+// numeric IDs work without them. An empty spec is root.
+func resolveUser(spec string, passwd, group io.Reader) (*execUser, error) {
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+	if userSpec == "" {
+		userSpec = "0"
+	}
+
+	u := &execUser{Home: "/"}
+	users, err := parseIDFile(passwd, 6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /etc/passwd: %v", err)
+	}
+	uid, numeric := parseID(userSpec)
+	name := ""
+	for _, fields := range users {
+		id, ok := parseID(fields[2])
+		if !ok || !(fields[0] == userSpec || numeric && id == uid) {
+			continue
+		}
+		gid, _ := parseID(fields[3])
+		u.UID, u.GID, u.Home = id, gid, fields[5]
+		name = fields[0]
+		break
+	}
+	if name == "" {
+		if !numeric {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in /etc/passwd", userSpec)
+		}
+		u.UID = uid
+	}
+
+	groups, err := parseIDFile(group, 4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /etc/group: %v", err)
+	}
+
+	if hasGroup {
+		gid, numeric := parseID(groupSpec)
+		found := numeric
+		for _, fields := range groups {
+			if fields[0] == groupSpec {
+				gid, found = 0, true
+				if id, ok := parseID(fields[2]); ok {
+					gid = id
+				}
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find group %s: no matching entries in /etc/group", groupSpec)
+		}
+		u.GID = gid
+		return u, nil
+	}
+
+	// Without an explicit group the user gets the groups it is a member of
+	for _, fields := range groups {
+		gid, ok := parseID(fields[2])
+		if !ok || gid == u.GID || name == "" {
+			continue
+		}
+		if slices.Contains(strings.Split(fields[3], ","), name) {
+			u.Groups = append(u.Groups, gid)
+		}
+	}
+	return u, nil
+}
+
+// parseIDFile splits the lines of a passwd or group file into fields and
+// skips lines with fewer than n fields.
+func parseIDFile(r io.Reader, n int) ([][]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	var entries [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Split(line, ":"); len(fields) >= n {
+			entries = append(entries, fields)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func parseID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err == nil
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// lookupUser resolves the user of a process inside the root filesystem of
+// the container, which must have been entered already.
+func lookupUser(spec string) (*execUser, error) {
+	var files [2]io.Reader
+	for i, name := range []string{"/etc/passwd", "/etc/group"} {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	return resolveUser(spec, files[0], files[1])
+}
+
+// userCredential returns the credential to run a process of the user with,
+// nil for root. IDs can only be used if they are mapped into the user
+// namespace of the container. Running a user that is not mapped as root
+// instead would give the process privileges the image meant to drop, so it
+// is an error.
+func userCredential(spec string, u *execUser) (*syscall.Credential, error) {
+	if u.UID == 0 && u.GID == 0 && len(u.Groups) == 0 {
+		return nil, nil
+	}
+	if !idMapped("/proc/self/uid_map", u.UID) || !idMapped("/proc/self/gid_map", u.GID) {
+		return nil, fmt.Errorf("user %s is not mapped into the container, subordinate IDs are needed to run it", spec)
+	}
+
+	cred := &syscall.Credential{Uid: u.UID, Gid: u.GID}
+	for _, gid := range u.Groups {
+		if idMapped("/proc/self/gid_map", gid) {
+			cred.Groups = append(cred.Groups, gid)
+		}
+	}
+	// Unprivileged user namespaces with a single mapped ID deny setgroups,
+	// the process then keeps the groups it was started with
+	if data, err := os.ReadFile("/proc/self/setgroups"); err == nil && strings.TrimSpace(string(data)) == "deny" {
+		cred.NoSetGroups = true
+	}
+	return cred, nil
+}
+
+// setCredential switches the current process to the credential.
+func setCredential(cred *syscall.Credential) error {
+	if cred == nil {
+		return nil
+	}
+	if !cred.NoSetGroups {
+		groups := make([]int, 0, len(cred.Groups))
+		for _, gid := range cred.Groups {
+			groups = append(groups, int(gid))
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set groups: %v", err)
+		}
+	}
+	if err := syscall.Setgid(int(cred.Gid)); err != nil {
+		return fmt.Errorf("failed to set gid %d: %v", cred.Gid, err)
+	}
+	if err := syscall.Setuid(int(cred.Uid)); err != nil {
+		return fmt.Errorf("failed to set uid %d: %v", cred.Uid, err)
+	}
+	return nil
+}
+
+// idMapped reports whether id is mapped in the uid_map or gid_map file.
+func idMapped(file string, id uint32) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var inside, outside, size uint32
+		if _, err := fmt.Sscan(strings.TrimSpace(scanner.Text()), &inside, &outside, &size); err != nil {
+			continue
+		}
+		if id >= inside && uint64(id) < uint64(inside)+uint64(size) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/sh
+# comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+app:x:1000:1000::/home/app:/bin/sh
+`
+
+const testGroup = `root:x:0:
+daemon:x:1:
+www-data:x:33:app
+app:x:1000:
+docker:x:999:app,daemon
+`
+
+func TestResolveUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		noFiles bool
+		want    *execUser
+		wantErr bool
+	}{
+		{name: "empty is root", spec: "", want: &execUser{Home: "/root"}},
+		{name: "user name", spec: "app", want: &execUser{UID: 1000, GID: 1000, Groups: []uint32{33, 999}, Home: "/home/app"}},
+		{name: "uid", spec: "33", want: &execUser{UID: 33, GID: 33, Home: "/var/www"}},
+		{name: "user and group name", spec: "app:docker", want: &execUser{UID: 1000, GID: 999, Home: "/home/app"}},
+		{name: "uid and gid", spec: "1000:5000", want: &execUser{UID: 1000, GID: 5000, Home: "/home/app"}},
+		{name: "unknown uid", spec: "4242", want: &execUser{UID: 4242, Home: "/"}},
+		{name: "numeric without files", spec: "1000:1000", noFiles: true, want: &execUser{UID: 1000, GID: 1000, Home: "/"}},
+		{name: "unknown user", spec: "nobody", wantErr: true},
+		{name: "unknown group", spec: "app:staff", wantErr: true},
+		{name: "name without files", spec: "app", noFiles: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *execUser
+			var err error
+			if tt.noFiles {
+				got, err = resolveUser(tt.spec, nil, nil)
+			} else {
+				got, err = resolveUser(tt.spec, strings.NewReader(testPasswd), strings.NewReader(testGroup))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveUser() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
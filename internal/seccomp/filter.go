@@ -0,0 +1,426 @@
+package seccomp
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Instruction is a classic BPF instruction, laid out like struct
+// sock_filter.
+type Instruction struct {
+	Code uint16 `json:"code"`
+	Jt   uint8  `json:"jt"`
+	Jf   uint8  `json:"jf"`
+	K    uint32 `json:"k"`
+}
+
+// Opcodes of the classic BPF instructions the filters use, from
+// linux/bpf_common.h.
+const (
+	bpfLdAbs = 0x00 | 0x00 | 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfAnd   = 0x04 | 0x50 | 0x00 // BPF_ALU | BPF_AND | BPF_K
+	bpfJeq   = 0x05 | 0x10 | 0x00 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgt   = 0x05 | 0x20 | 0x00 // BPF_JMP | BPF_JGT | BPF_K
+	bpfJge   = 0x05 | 0x30 | 0x00 // BPF_JMP | BPF_JGE | BPF_K
+	bpfJa    = 0x05 | 0x00        // BPF_JMP | BPF_JA
+	bpfRet   = 0x06 | 0x00        // BPF_RET | BPF_K
+)
+
+// maxInstructions is the longest filter the kernel accepts.
+const maxInstructions = 4096
+
+// Offsets in struct seccomp_data, the input of the filter. Arguments are
+// 64 bits wide and stored in the byte order of the architecture.
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// arch describes an architecture filters can be compiled for.
+type arch struct {
+	goarch   string
+	name     string // name in profiles
+	audit    uint32 // AUDIT_ARCH_* from linux/audit.h
+	syscalls map[string]uint32
+	// subArches are the architectures whose programs the kernel also runs
+	subArches []arch
+}
+
+// x32Bit is set in the numbers of x32 syscalls, which have the audit arch of
+// amd64.
+const x32Bit = 0x40000000
+
+var (
+	archX86   = arch{name: "SCMP_ARCH_X86", audit: 0x40000003, syscalls: syscalls386}
+	archX32   = arch{name: "SCMP_ARCH_X32", audit: 0xc000003e, syscalls: syscallsX32}
+	archARM   = arch{name: "SCMP_ARCH_ARM", audit: 0x40000028, syscalls: syscallsARM}
+	archAMD64 = arch{goarch: "amd64", name: "SCMP_ARCH_X86_64", audit: 0xc000003e, syscalls: syscallsAMD64, subArches: []arch{archX86, archX32}}
+	archARM64 = arch{goarch: "arm64", name: "SCMP_ARCH_AARCH64", audit: 0xc00000b7, syscalls: syscallsARM64, subArches: []arch{archARM}}
+)
+
+var arches = []arch{archAMD64, archARM64}
+
+func findArch(goarch string) (arch, error) {
+	for _, a := range arches {
+		if a.goarch == goarch {
+			return a, nil
+		}
+	}
+	return arch{}, fmt.Errorf("seccomp is not supported on %s", goarch)
+}
+
+// architectures returns the names of the architectures the profile allows on
+// the target: those archMap lists for it, otherwise those of architectures.
+// Without either only the architecture of the target is allowed.
+func (p *Profile) architectures(t target) []string {
+	for _, m := range p.ArchMap {
+		if m.Architecture == t.arch.name {
+			return append([]string{m.Architecture}, m.SubArchitectures...)
+		}
+	}
+	if len(p.Architectures) > 0 {
+		return p.Architectures
+	}
+	return []string{t.arch.name}
+}
+
+// target is the system a filter is compiled for.
+type target struct {
+	arch         arch
+	capabilities []string
+	kernel       kernelVersion
+}
+
+// applies reports whether a rule applies to the target.
+func (t target) applies(s Syscall) bool {
+	for _, c := range s.Includes.Caps {
+		if !slices.Contains(t.capabilities, c) {
+			return false
+		}
+	}
+	if len(s.Includes.Arches) > 0 && !slices.Contains(s.Includes.Arches, t.arch.goarch) {
+		return false
+	}
+	if s.Includes.MinKernel != "" {
+		if v, _ := parseKernelVersion(s.Includes.MinKernel); t.kernel.less(v) {
+			return false
+		}
+	}
+
+	for _, c := range s.Excludes.Caps {
+		if slices.Contains(t.capabilities, c) {
+			return false
+		}
+	}
+	if slices.Contains(s.Excludes.Arches, t.arch.goarch) {
+		return false
+	}
+	if s.Excludes.MinKernel != "" {
+		if v, _ := parseKernelVersion(s.Excludes.MinKernel); !t.kernel.less(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the rules of the profile that apply to the target, without
+// the fields only Docker understands.
+func resolve(p *Profile, t target) *Profile {
+	r := &Profile{
+		DefaultAction:   p.DefaultAction,
+		DefaultErrnoRet: p.DefaultErrnoRet,
+		Architectures:   p.architectures(t),
+	}
+	for _, s := range p.Syscalls {
+		if t.applies(s) {
+			r.Syscalls = append(r.Syscalls, Syscall{Names: s.names(), Action: s.Action, ErrnoRet: s.ErrnoRet, Args: s.Args})
+		}
+	}
+	return r
+}
+
+// rule is a syscall rule that applies to the target.
+type rule struct {
+	action uint32
+	args   []Arg
+}
+
+// compile compiles the profile into a filter for the target. The filter
+// first jumps to the rules of the architecture of the syscall, the target's
+// or one of its sub-architectures the profile allows, like x86 on amd64.
+// Other architectures, which would have other syscall numbers, are killed.
+// The rules check the syscalls one after another, the rules of a syscall in
+// the order of the profile. The first rule whose arguments match decides,
+// without one the default action does. Syscalls unknown to an architecture
+// are skipped, as profiles list those of all architectures. Profiles listing
+// their architectures must include the one of the target.
+func compile(p *Profile, t target) ([]Instruction, error) {
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %v", err)
+	}
+	defaultAction, err := action(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	names := p.architectures(t)
+	if !slices.Contains(names, t.arch.name) {
+		return nil, fmt.Errorf("seccomp profile does not support %s", t.arch.name)
+	}
+	archs := []arch{t.arch}
+	for _, sub := range t.arch.subArches {
+		if slices.Contains(names, sub.name) {
+			archs = append(archs, sub)
+		}
+	}
+
+	var a assembler
+	kill := a.newLabel()
+	blocks := make([]label, len(archs))
+	for i := range archs {
+		blocks[i] = a.newLabel()
+	}
+
+	// x32 shares the audit arch of amd64 and is told apart by its syscall
+	// numbers, it is checked in the block of amd64
+	x32 := kill
+	a.load(offsetArch)
+	for i, ar := range archs {
+		if ar.name == archX32.name {
+			x32 = blocks[i]
+			continue
+		}
+		a.jump(bpfJeq, ar.audit, next, a.skip(1))
+		a.jumpTo(blocks[i])
+	}
+	a.jumpTo(kill)
+
+	for i, ar := range archs {
+		a.place(blocks[i])
+		a.load(offsetNr)
+		if ar.name == archAMD64.name {
+			a.jump(bpfJge, x32Bit, next, a.skip(1))
+			a.jumpTo(x32)
+		}
+		if err := a.syscalls(p, t, ar.syscalls, defaultAction); err != nil {
+			return nil, err
+		}
+		a.ret(defaultAction)
+	}
+	a.place(kill)
+	a.ret(retKillProcess)
+
+	return a.assemble()
+}
+
+// syscalls checks the syscall number in the accumulator against the rules of
+// the profile that apply to the target, with the numbers of an architecture.
+// Syscalls without a rule fall through.
+func (a *assembler) syscalls(p *Profile, t target, numbers map[string]uint32, defaultAction uint32) error {
+	var order []uint32
+	rules := make(map[uint32][]rule)
+	for _, s := range p.Syscalls {
+		if !t.applies(s) {
+			continue
+		}
+		ret, err := action(s.Action, s.ErrnoRet)
+		if err != nil {
+			return err
+		}
+		for _, name := range s.names() {
+			nr, ok := numbers[name]
+			if !ok {
+				continue
+			}
+			if _, seen := rules[nr]; !seen {
+				order = append(order, nr)
+			}
+			rules[nr] = append(rules[nr], rule{action: ret, args: s.Args})
+		}
+	}
+
+	for _, nr := range order {
+		end := a.newLabel()
+		a.jump(bpfJeq, nr, next, end)
+		for _, r := range rules[nr] {
+			nextRule := a.newLabel()
+			for _, arg := range r.args {
+				a.compare(arg, nextRule)
+			}
+			a.ret(r.action)
+			a.place(nextRule)
+		}
+		// The arguments were loaded, the syscall number is not in the
+		// accumulator anymore
+		if len(rules[nr][len(rules[nr])-1].args) > 0 {
+			a.ret(defaultAction)
+		}
+		a.place(end)
+	}
+	return nil
+}
+
+// compare jumps to fail unless the argument matches.
+func (a *assembler) compare(arg Arg, fail label) {
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	value := [2]uint32{uint32(arg.Value >> 32), uint32(arg.Value)}
+
+	switch arg.Op {
+	case "SCMP_CMP_EQ":
+		a.load(hi)
+		a.jump(bpfJeq, value[0], next, fail)
+		a.load(lo)
+		a.jump(bpfJeq, value[1], next, fail)
+	case "SCMP_CMP_NE":
+		ok := a.newLabel()
+		a.load(hi)
+		a.jump(bpfJeq, value[0], next, ok)
+		a.load(lo)
+		a.jump(bpfJeq, value[1], fail, ok)
+		a.place(ok)
+	case "SCMP_CMP_MASKED_EQ":
+		want := [2]uint32{uint32(arg.ValueTwo >> 32), uint32(arg.ValueTwo)}
+		a.load(hi)
+		a.stmt(bpfAnd, value[0])
+		a.jump(bpfJeq, want[0], next, fail)
+		a.load(lo)
+		a.stmt(bpfAnd, value[1])
+		a.jump(bpfJeq, want[1], next, fail)
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		// Greater if the high word is, or it is equal and the low word is
+		ok, low := a.newLabel(), a.newLabel()
+		a.load(hi)
+		a.jump(bpfJgt, value[0], ok, next)
+		a.jump(bpfJeq, value[0], low, fail)
+		a.place(low)
+		a.load(lo)
+		if arg.Op == "SCMP_CMP_GT" {
+			a.jump(bpfJgt, value[1], ok, fail)
+		} else {
+			a.jump(bpfJge, value[1], ok, fail)
+		}
+		a.place(ok)
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		// Less if the high word is, or it is equal and the low word is
+		ok, low := a.newLabel(), a.newLabel()
+		a.load(hi)
+		a.jump(bpfJge, value[0], next, ok)
+		a.jump(bpfJeq, value[0], low, fail)
+		a.place(low)
+		a.load(lo)
+		if arg.Op == "SCMP_CMP_LT" {
+			a.jump(bpfJge, value[1], fail, ok)
+		} else {
+			a.jump(bpfJgt, value[1], fail, ok)
+		}
+		a.place(ok)
+	}
+}
+
+// operators are the comparisons compare supports.
+var operators = map[string]bool{
+	"SCMP_CMP_EQ":        true,
+	"SCMP_CMP_NE":        true,
+	"SCMP_CMP_MASKED_EQ": true,
+	"SCMP_CMP_GT":        true,
+	"SCMP_CMP_GE":        true,
+	"SCMP_CMP_LT":        true,
+	"SCMP_CMP_LE":        true,
+}
+
+// label is a position in a program that jumps can target before it is
+// known.
+type label int
+
+// next continues with the following instruction.
+const next label = -1
+
+type jumpTarget struct {
+	pc     int
+	jt, jf label
+	always bool // BPF_JA, which jumps to jt by K
+}
+
+// assembler builds a BPF program. Classic BPF only jumps forward. Conditional
+// jumps go at most 255 instructions, which compile keeps to by jumping within
+// a syscall, and farther targets are reached through an unconditional jump.
+type assembler struct {
+	prog   []Instruction
+	labels []int // program counter of each label, -1 until placed
+	jumps  []jumpTarget
+}
+
+func (a *assembler) newLabel() label {
+	a.labels = append(a.labels, -1)
+	return label(len(a.labels) - 1)
+}
+
+func (a *assembler) place(l label) {
+	a.labels[l] = len(a.prog)
+}
+
+// skip returns a label n instructions after the next one.
+func (a *assembler) skip(n int) label {
+	l := a.newLabel()
+	a.labels[l] = len(a.prog) + 1 + n
+	return l
+}
+
+func (a *assembler) stmt(code uint16, k uint32) {
+	a.prog = append(a.prog, Instruction{Code: code, K: k})
+}
+
+func (a *assembler) load(offset uint32) {
+	a.stmt(bpfLdAbs, offset)
+}
+
+func (a *assembler) ret(k uint32) {
+	a.stmt(bpfRet, k)
+}
+
+func (a *assembler) jump(code uint16, k uint32, jt, jf label) {
+	a.jumps = append(a.jumps, jumpTarget{pc: len(a.prog), jt: jt, jf: jf})
+	a.stmt(code, k)
+}
+
+// jumpTo jumps to l unconditionally, as far as needed.
+func (a *assembler) jumpTo(l label) {
+	a.jumps = append(a.jumps, jumpTarget{pc: len(a.prog), jt: l, always: true})
+	a.stmt(bpfJa, 0)
+}
+
+func (a *assembler) assemble() ([]Instruction, error) {
+	if len(a.prog) > maxInstructions {
+		return nil, fmt.Errorf("seccomp filter has %d instructions, the kernel allows at most %d", len(a.prog), maxInstructions)
+	}
+	offset := func(pc int, l label) (uint8, error) {
+		if l == next {
+			return 0, nil
+		}
+		d := a.labels[l] - pc - 1
+		if a.labels[l] < 0 || d < 0 || d > 255 {
+			return 0, fmt.Errorf("seccomp filter jumps too far at instruction %d", pc)
+		}
+		return uint8(d), nil
+	}
+
+	for _, j := range a.jumps {
+		if j.always {
+			d := a.labels[j.jt] - j.pc - 1
+			if a.labels[j.jt] < 0 || d < 0 {
+				return nil, fmt.Errorf("seccomp filter jumps backwards at instruction %d", j.pc)
+			}
+			a.prog[j.pc].K = uint32(d)
+			continue
+		}
+		var err error
+		if a.prog[j.pc].Jt, err = offset(j.pc, j.jt); err != nil {
+			return nil, err
+		}
+		if a.prog[j.pc].Jf, err = offset(j.pc, j.jf); err != nil {
+			return nil, err
+		}
+	}
+	return a.prog, nil
+}
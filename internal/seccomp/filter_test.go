@@ -0,0 +1,299 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// syscall is the input of a filter, like struct seccomp_data.
+type syscall struct {
+	nr   uint32
+	arch uint32
+	args [maxArgs]uint64
+}
+
+// run interprets a filter for the syscall the way the kernel does.
+func run(t *testing.T, filter []Instruction, s syscall) uint32 {
+	t.Helper()
+	data := make([]byte, offsetArgs+8*maxArgs)
+	binary.LittleEndian.PutUint32(data[offsetNr:], s.nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], s.arch)
+	for i, a := range s.args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], a)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		in := filter[pc]
+		switch in.Code {
+		case bpfLdAbs:
+			acc = binary.LittleEndian.Uint32(data[in.K:])
+		case bpfAnd:
+			acc &= in.K
+		case bpfJeq, bpfJgt, bpfJge:
+			var match bool
+			switch in.Code {
+			case bpfJeq:
+				match = acc == in.K
+			case bpfJgt:
+				match = acc > in.K
+			case bpfJge:
+				match = acc >= in.K
+			}
+			if match {
+				pc += int(in.Jt)
+			} else {
+				pc += int(in.Jf)
+			}
+		case bpfJa:
+			pc += int(in.K)
+		case bpfRet:
+			return in.K
+		default:
+			t.Fatalf("unknown instruction %#x at %d", in.Code, pc)
+		}
+	}
+	t.Fatal("filter did not return")
+	return 0
+}
+
+func compileFor(t *testing.T, p *Profile, goarch string, caps ...string) ([]Instruction, arch) {
+	t.Helper()
+	a, err := findArch(goarch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := compile(p, target{arch: a, capabilities: caps, kernel: kernelVersion{6, 1}})
+	if err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+	return filter, a
+}
+
+func TestCompileOperators(t *testing.T) {
+	const big = 0x1_0000_0005
+	tests := []struct {
+		op    string
+		value uint64
+		two   uint64
+		args  map[uint64]bool // argument values and whether they match
+	}{
+		{"SCMP_CMP_EQ", big, 0, map[uint64]bool{big: true, 5: false, big + 1: false}},
+		{"SCMP_CMP_NE", big, 0, map[uint64]bool{big: false, 5: true, 0x2_0000_0005: true}},
+		{"SCMP_CMP_GT", big, 0, map[uint64]bool{big: false, big + 1: true, 6: false, 0x2_0000_0000: true}},
+		{"SCMP_CMP_GE", big, 0, map[uint64]bool{big: true, big - 1: false, 0x2_0000_0000: true, 0: false}},
+		{"SCMP_CMP_LT", big, 0, map[uint64]bool{big: false, big - 1: true, 7: true, 0x2_0000_0000: false}},
+		{"SCMP_CMP_LE", big, 0, map[uint64]bool{big: true, big + 1: false, 0: true, 0x1_0000_0100: false}},
+		{"SCMP_CMP_MASKED_EQ", 0x7E020000, 0, map[uint64]bool{0x11: true, 0x20000: false, 0x1_0000_0000: true}},
+		{"SCMP_CMP_MASKED_EQ", 0xff_0000_00ff, 0x12_0000_0034, map[uint64]bool{0x12_1111_1134: true, 0x34: false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			p := &Profile{DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []Syscall{{
+				Names:  []string{"personality"},
+				Action: "SCMP_ACT_ERRNO",
+				Args:   []Arg{{Index: 2, Value: tt.value, ValueTwo: tt.two, Op: tt.op}},
+			}}}
+			filter, a := compileFor(t, p, "amd64")
+			for arg, match := range tt.args {
+				s := syscall{nr: a.syscalls["personality"], arch: a.audit}
+				s.args[2] = arg
+				want := uint32(retAllow)
+				if match {
+					want = retErrno | errnoEPERM
+				}
+				if got := run(t, filter, s); got != want {
+					t.Errorf("argument %#x: got %#x, want %#x", arg, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	errno := func(n uint) *uint { return &n }
+	p := &Profile{DefaultAction: "SCMP_ACT_ERRNO", Syscalls: []Syscall{
+		{Names: []string{"read", "write", "no_such_syscall"}, Action: "SCMP_ACT_ALLOW"},
+		{Names: []string{"mount"}, Action: "SCMP_ACT_ALLOW", Includes: Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		{Names: []string{"clone3"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: errno(38), Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+		{Names: []string{"arch_prctl"}, Action: "SCMP_ACT_ALLOW", Includes: Filter{Arches: []string{"amd64"}}},
+		{Names: []string{"ptrace"}, Action: "SCMP_ACT_ALLOW", Includes: Filter{MinKernel: "6.2"}},
+		{Names: []string{"kill"}, Action: "SCMP_ACT_KILL_PROCESS", Args: []Arg{{Index: 1, Value: 9, Op: "SCMP_CMP_EQ"}}},
+		{Names: []string{"kill"}, Action: "SCMP_ACT_LOG", Args: []Arg{{Index: 0, Value: 1, Op: "SCMP_CMP_EQ"}, {Index: 1, Value: 15, Op: "SCMP_CMP_EQ"}}},
+		{Names: []string{"kill"}, Action: "SCMP_ACT_ALLOW", Args: []Arg{{Index: 1, Value: 15, Op: "SCMP_CMP_EQ"}}},
+	}}
+	deny := uint32(retErrno | errnoEPERM)
+
+	tests := []struct {
+		name string
+		caps []string
+		call string
+		args []uint64
+		want uint32
+	}{
+		{name: "allowed", call: "read", want: retAllow},
+		{name: "second name", call: "write", want: retAllow},
+		{name: "not listed", call: "unshare", want: deny},
+		{name: "without capability", call: "mount", want: deny},
+		{name: "with capability", caps: []string{"CAP_SYS_ADMIN"}, call: "mount", want: retAllow},
+		{name: "excluded capability missing", call: "clone3", want: retErrno | 38},
+		{name: "excluded capability", caps: []string{"CAP_SYS_ADMIN"}, call: "clone3", want: deny},
+		{name: "architecture", call: "arch_prctl", want: retAllow},
+		{name: "newer kernel", call: "ptrace", want: deny},
+		{name: "first rule", call: "kill", args: []uint64{1, 9}, want: retKillProcess},
+		{name: "all arguments", call: "kill", args: []uint64{1, 15}, want: retLog},
+		{name: "later rule", call: "kill", args: []uint64{2, 15}, want: retAllow},
+		{name: "no rule", call: "kill", args: []uint64{1, 2}, want: deny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, a := compileFor(t, p, "amd64", tt.caps...)
+			nr, ok := a.syscalls[tt.call]
+			if !ok {
+				t.Fatalf("unknown syscall %s", tt.call)
+			}
+			s := syscall{nr: nr, arch: a.audit}
+			copy(s.args[:], tt.args)
+			if got := run(t, filter, s); got != tt.want {
+				t.Errorf("got %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileArchitectures(t *testing.T) {
+	p := &Profile{DefaultAction: "SCMP_ACT_ALLOW", Syscalls: []Syscall{
+		{Names: []string{"arch_prctl"}, Action: "SCMP_ACT_ERRNO", Includes: Filter{Arches: []string{"amd64"}}},
+	}}
+
+	filter, a := compileFor(t, p, "amd64")
+	if got := run(t, filter, syscall{nr: a.syscalls["read"], arch: 0x40000003}); got != retKillProcess {
+		t.Errorf("i386 syscall: got %#x, want %#x", got, retKillProcess)
+	}
+	if got := run(t, filter, syscall{nr: 0x40000000, arch: a.audit}); got != retKillProcess {
+		t.Errorf("x32 syscall: got %#x, want %#x", got, retKillProcess)
+	}
+	if got := run(t, filter, syscall{nr: a.syscalls["read"], arch: a.audit}); got != retAllow {
+		t.Errorf("amd64 syscall: got %#x, want %#x", got, retAllow)
+	}
+
+	// arch_prctl only exists on amd64, the rule does not apply on arm64
+	filter, a = compileFor(t, p, "arm64")
+	if _, ok := a.syscalls["arch_prctl"]; ok {
+		t.Fatal("arm64 has arch_prctl")
+	}
+	if got := run(t, filter, syscall{nr: a.syscalls["read"], arch: a.audit}); got != retAllow {
+		t.Errorf("arm64 syscall: got %#x, want %#x", got, retAllow)
+	}
+
+	// Sub-architectures run their own syscall numbers once the profile lists
+	// them, x86 with its audit arch and x32 with the x32 bit
+	p.Architectures = []string{"SCMP_ARCH_X86_64", "SCMP_ARCH_X86", "SCMP_ARCH_X32"}
+	filter, a = compileFor(t, p, "amd64")
+	if got := run(t, filter, syscall{nr: syscalls386["read"], arch: archX86.audit}); got != retAllow {
+		t.Errorf("i386 syscall: got %#x, want %#x", got, retAllow)
+	}
+	if got := run(t, filter, syscall{nr: syscallsX32["read"], arch: a.audit}); got != retAllow {
+		t.Errorf("x32 syscall: got %#x, want %#x", got, retAllow)
+	}
+	if got := run(t, filter, syscall{nr: a.syscalls["arch_prctl"], arch: a.audit}); got != retErrno|errnoEPERM {
+		t.Errorf("amd64 arch_prctl: got %#x, want %#x", got, retErrno|errnoEPERM)
+	}
+	if got := run(t, filter, syscall{nr: syscallsARM["read"], arch: archARM.audit}); got != retKillProcess {
+		t.Errorf("arm syscall: got %#x, want %#x", got, retKillProcess)
+	}
+
+	p.Architectures = []string{"SCMP_ARCH_AARCH64"}
+	a, _ = findArch("amd64")
+	if _, err := compile(p, target{arch: a}); err == nil {
+		t.Error("compile() for an architecture the profile does not list succeeded")
+	}
+}
+
+func TestCompileDefaultProfile(t *testing.T) {
+	for _, goarch := range []string{"amd64", "arm64"} {
+		t.Run(goarch, func(t *testing.T) {
+			filter, a := compileFor(t, DefaultProfile(), goarch, "CAP_CHOWN", "CAP_SETUID")
+			if len(filter) > maxInstructions {
+				t.Fatalf("filter has %d instructions", len(filter))
+			}
+
+			deny := uint32(retErrno | errnoEPERM)
+			tests := []struct {
+				call string
+				args []uint64
+				want uint32
+			}{
+				{call: "openat", want: retAllow},
+				{call: "execve", want: retAllow},
+				{call: "setuid", want: retAllow},
+				{call: "mount", want: deny},
+				{call: "unshare", want: deny},
+				{call: "kexec_load", want: deny},
+				{call: "personality", args: []uint64{0x20008}, want: retAllow},
+				{call: "personality", args: []uint64{0x0400000}, want: deny},
+				// Threads may be created, namespaces not
+				{call: "clone", args: []uint64{0x3d0f00}, want: retAllow},
+				{call: "clone", args: []uint64{0x20000000}, want: deny},
+				{call: "clone3", want: retErrno | 38},
+			}
+			for _, tt := range tests {
+				s := syscall{nr: a.syscalls[tt.call], arch: a.audit}
+				copy(s.args[:], tt.args)
+				if got := run(t, filter, s); got != tt.want {
+					t.Errorf("%s%#x: got %#x, want %#x", tt.call, tt.args, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	a, _ := findArch("amd64")
+	tg := target{arch: a, capabilities: []string{"CAP_SYS_ADMIN"}, kernel: kernelVersion{6, 1}}
+	r := resolve(DefaultProfile(), tg)
+
+	if len(r.ArchMap) != 0 {
+		t.Errorf("ArchMap = %v, want none", r.ArchMap)
+	}
+	if len(r.Architectures) == 0 || r.Architectures[0] != "SCMP_ARCH_X86_64" {
+		t.Errorf("Architectures = %v, want SCMP_ARCH_X86_64 first", r.Architectures)
+	}
+	for _, s := range r.Syscalls {
+		if len(s.Includes.Caps)+len(s.Includes.Arches)+len(s.Excludes.Caps)+len(s.Excludes.Arches) > 0 || s.Includes.MinKernel != "" || s.Excludes.MinKernel != "" {
+			t.Errorf("rule for %v kept includes or excludes", s.Names)
+		}
+	}
+
+	// The resolved profile compiles to a filter deciding like the original
+	want, err := compile(DefaultProfile(), tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := compile(r, tg)
+	if err != nil {
+		t.Fatalf("compile() of the resolved profile failed: %v", err)
+	}
+	for _, call := range []string{"openat", "mount", "unshare", "kexec_load", "arch_prctl", "clone3"} {
+		s := syscall{nr: a.syscalls[call], arch: a.audit}
+		if g, w := run(t, got, s), run(t, want, s); g != w {
+			t.Errorf("%s: got %#x, want %#x", call, g, w)
+		}
+	}
+}
+
+func TestAssembleJumpTooFar(t *testing.T) {
+	var a assembler
+	end := a.newLabel()
+	a.jump(bpfJeq, 0, next, end)
+	for range 256 {
+		a.ret(retAllow)
+	}
+	a.place(end)
+	a.ret(retAllow)
+	if _, err := a.assemble(); err == nil {
+		t.Error("assemble() with a jump over 256 instructions succeeded")
+	}
+}
@@ -0,0 +1,158 @@
+//go:build ignore
+
+// mksyscalls generates the syscall tables of the supported architectures
+// from the syscall numbers of golang.org/x/sys/unix. x32, which Go does not
+// support, is derived from amd64.
+//
+//	go run mksyscalls.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var tables = []struct {
+	goarch string
+	file   string
+	name   string
+}{
+	{"amd64", "syscalls_x86_64.go", "syscallsAMD64"},
+	{"arm64", "syscalls_aarch64.go", "syscallsARM64"},
+	{"386", "syscalls_x86.go", "syscalls386"},
+	{"arm", "syscalls_armeabi.go", "syscallsARM"},
+}
+
+// x32Bit is set in the numbers of x32 syscalls, __X32_SYSCALL_BIT.
+const x32Bit = 0x40000000
+
+// x32Syscalls are the syscalls x32 has its own numbers for, from
+// arch/x86/entry/syscalls/syscall_64.tbl. The others have the number of
+// amd64.
+var x32Syscalls = map[string]int{
+	"rt_sigaction":      512,
+	"rt_sigreturn":      513,
+	"ioctl":             514,
+	"readv":             515,
+	"writev":            516,
+	"recvfrom":          517,
+	"sendmsg":           518,
+	"recvmsg":           519,
+	"execve":            520,
+	"ptrace":            521,
+	"rt_sigpending":     522,
+	"rt_sigtimedwait":   523,
+	"rt_sigqueueinfo":   524,
+	"sigaltstack":       525,
+	"timer_create":      526,
+	"mq_notify":         527,
+	"kexec_load":        528,
+	"waitid":            529,
+	"set_robust_list":   530,
+	"get_robust_list":   531,
+	"vmsplice":          532,
+	"move_pages":        533,
+	"preadv":            534,
+	"pwritev":           535,
+	"rt_tgsigqueueinfo": 536,
+	"recvmmsg":          537,
+	"sendmmsg":          538,
+	"process_vm_readv":  539,
+	"process_vm_writev": 540,
+	"setsockopt":        541,
+	"getsockopt":        542,
+	"io_setup":          543,
+	"io_submit":         544,
+	"execveat":          545,
+	"preadv2":           546,
+	"pwritev2":          547,
+}
+
+var sysnum = regexp.MustCompile(`^\s*SYS_(\w+)\s*=\s*(\d+)`)
+
+func main() {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sys").Output()
+	if err != nil {
+		log.Fatalf("failed to find golang.org/x/sys: %v", err)
+	}
+	dir := filepath.Join(strings.TrimSpace(string(out)), "unix")
+
+	for _, t := range tables {
+		syscalls := readSyscalls(filepath.Join(dir, "zsysnum_linux_"+t.goarch+".go"))
+		write(t.file, t.name, "linux/"+t.goarch, syscalls)
+
+		if t.goarch == "amd64" {
+			x32 := make(map[string]int)
+			for name, nr := range syscalls {
+				if x, ok := x32Syscalls[name]; ok {
+					nr = x
+				}
+				x32[name] = x32Bit | nr
+			}
+			write("syscalls_x32.go", "syscallsX32", "x32", x32)
+		}
+	}
+}
+
+func readSyscalls(file string) map[string]int {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	syscalls := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// SYS_SYSCALL_MASK of arm is a mask, not a syscall
+		if m := sysnum.FindStringSubmatch(scanner.Text()); m != nil && m[1] != "SYSCALL_MASK" {
+			var nr int
+			fmt.Sscan(m[2], &nr)
+			syscalls[strings.ToLower(m[1])] = nr
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return syscalls
+}
+
+func write(file, name, arch string, syscalls map[string]int) {
+	names := make([]string, 0, len(syscalls))
+	for n := range syscalls {
+		names = append(names, n)
+	}
+	// In the order of the numbers, like the tables of the kernel
+	sort.Slice(names, func(i, j int) bool {
+		if syscalls[names[i]] != syscalls[names[j]] {
+			return syscalls[names[i]] < syscalls[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mksyscalls.go; DO NOT EDIT.\n\npackage seccomp\n\n")
+	fmt.Fprintf(&buf, "// %s maps the names of the syscalls of %s to their numbers.\n", name, arch)
+	fmt.Fprintf(&buf, "var %s = map[string]uint32{\n", name)
+	for _, n := range names {
+		fmt.Fprintf(&buf, "\t%q: %d,\n", n, syscalls[n])
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(file, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+// Package seccomp compiles seccomp profiles in the JSON format of Docker and
+// the OCI runtime spec to BPF filters the kernel can install.
+package seccomp
+
+//go:generate go run mksyscalls.go
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Profile is a seccomp profile. The OCI runtime spec uses the same fields
+// apart from archMap, includes and excludes, which Docker added.
+type Profile struct {
+	DefaultAction   string    `json:"defaultAction"`
+	DefaultErrnoRet *uint     `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	ArchMap         []ArchMap `json:"archMap,omitempty"`
+	Syscalls        []Syscall `json:"syscalls,omitempty"`
+}
+
+// ArchMap lists an architecture with the sub-architectures it can run.
+type ArchMap struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures,omitempty"`
+}
+
+// Syscall is a rule applying an action to syscalls, optionally only when
+// their arguments match all of Args.
+type Syscall struct {
+	Names    []string `json:"names,omitempty"`
+	Name     string   `json:"name,omitempty"` // older profiles name one syscall per rule
+	Action   string   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []Arg    `json:"args,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes Filter   `json:"includes,omitzero"`
+	Excludes Filter   `json:"excludes,omitzero"`
+}
+
+// Arg compares an argument of a syscall with Value. For SCMP_CMP_MASKED_EQ
+// Value is the mask and ValueTwo the value the masked argument must equal.
+type Arg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// Filter limits a rule to containers with all of Caps, to the architectures
+// in Arches, given as GOARCH, and to kernels of at least MinKernel.
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// maxArgs is the number of arguments a syscall has at most.
+const maxArgs = 6
+
+// defaultProfile is the default profile of Docker, from profiles/seccomp of
+// github.com/moby/moby, with the archMap limited to the supported
+// architectures.
+//
+//go:embed default.json
+var defaultProfile []byte
+
+// DefaultProfile returns the profile containers run with unless another one
+// is given. Like Docker's it allows the syscalls common programs need and
+// those that the capabilities of the container allow.
+func DefaultProfile() *Profile {
+	p, err := ParseProfile(defaultProfile)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default seccomp profile: %v", err))
+	}
+	return p
+}
+
+// ParseProfile parses and validates a profile.
+func ParseProfile(data []byte) (*Profile, error) {
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %v", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %v", err)
+	}
+	return &p, nil
+}
+
+func (p *Profile) validate() error {
+	if p.DefaultAction == "" {
+		return fmt.Errorf("defaultAction is missing")
+	}
+	if _, err := action(p.DefaultAction, p.DefaultErrnoRet); err != nil {
+		return err
+	}
+	for _, s := range p.Syscalls {
+		if len(s.names()) == 0 {
+			return fmt.Errorf("rule without syscall names")
+		}
+		if _, err := action(s.Action, s.ErrnoRet); err != nil {
+			return fmt.Errorf("syscall %s: %v", s.names()[0], err)
+		}
+		for _, a := range s.Args {
+			if a.Index >= maxArgs {
+				return fmt.Errorf("syscall %s: argument index %d out of range", s.names()[0], a.Index)
+			}
+			if _, ok := operators[a.Op]; !ok {
+				return fmt.Errorf("syscall %s: unknown operator %q", s.names()[0], a.Op)
+			}
+		}
+		for _, v := range []string{s.Includes.MinKernel, s.Excludes.MinKernel} {
+			if _, err := parseKernelVersion(v); v != "" && err != nil {
+				return fmt.Errorf("syscall %s: %v", s.names()[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s Syscall) names() []string {
+	if s.Name != "" {
+		return append([]string{s.Name}, s.Names...)
+	}
+	return s.Names
+}
+
+// Return values of seccomp filters, from linux/seccomp.h.
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+)
+
+// errnoEPERM is returned by SCMP_ACT_ERRNO without an errnoRet.
+const errnoEPERM = 1
+
+// action returns the return value of the filter for a profile action.
+func action(name string, errnoRet *uint) (uint32, error) {
+	data := uint32(errnoEPERM)
+	if errnoRet != nil {
+		if *errnoRet > 0xffff {
+			return 0, fmt.Errorf("errnoRet %d out of range", *errnoRet)
+		}
+		data = uint32(*errnoRet)
+	}
+
+	switch name {
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return retKillThread, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return retKillProcess, nil
+	case "SCMP_ACT_TRAP":
+		return retTrap, nil
+	case "SCMP_ACT_ERRNO":
+		return retErrno | data, nil
+	case "SCMP_ACT_TRACE":
+		if errnoRet == nil {
+			data = 0
+		}
+		return retTrace | data, nil
+	case "SCMP_ACT_LOG":
+		return retLog, nil
+	case "SCMP_ACT_ALLOW":
+		return retAllow, nil
+	case "SCMP_ACT_NOTIFY":
+		return 0, fmt.Errorf("action %s is not supported", name)
+	default:
+		return 0, fmt.Errorf("unknown action %q", name)
+	}
+}
+
+// kernelVersion is the major and minor version of a kernel.
+type kernelVersion [2]int
+
+// parseKernelVersion parses versions like 4.8 or 6.1.0-13-amd64.
+func parseKernelVersion(s string) (kernelVersion, error) {
+	var v kernelVersion
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return v, fmt.Errorf("invalid kernel version %q", s)
+	}
+	// The minor version may be followed by a suffix like -rc1
+	minor := parts[1]
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	var err error
+	if v[0], err = strconv.Atoi(parts[0]); err != nil {
+		return v, fmt.Errorf("invalid kernel version %q", s)
+	}
+	if v[1], err = strconv.Atoi(minor); err != nil {
+		return v, fmt.Errorf("invalid kernel version %q", s)
+	}
+	return v, nil
+}
+
+func (v kernelVersion) less(other kernelVersion) bool {
+	return v[0] < other[0] || v[0] == other[0] && v[1] < other[1]
+}
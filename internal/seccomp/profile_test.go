@@ -0,0 +1,147 @@
+package seccomp
+
+import "testing"
+
+func TestDefaultProfile(t *testing.T) {
+	p := DefaultProfile()
+	if p.DefaultAction != "SCMP_ACT_ERRNO" {
+		t.Errorf("DefaultAction = %q, want SCMP_ACT_ERRNO", p.DefaultAction)
+	}
+	if len(p.Syscalls) == 0 {
+		t.Error("default profile has no syscalls")
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "minimal",
+			data: `{"defaultAction": "SCMP_ACT_ALLOW"}`,
+		},
+		{
+			name: "oci",
+			data: `{"defaultAction": "SCMP_ACT_ERRNO", "architectures": ["SCMP_ARCH_X86_64"], "syscalls": [
+				{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"},
+				{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]}
+			]}`,
+		},
+		{
+			name: "single name",
+			data: `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"name": "mount", "action": "SCMP_ACT_ERRNO", "errnoRet": 38}]}`,
+		},
+		{
+			name:    "not json",
+			data:    `defaultAction: SCMP_ACT_ALLOW`,
+			wantErr: true,
+		},
+		{
+			name:    "missing default action",
+			data:    `{"syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_MAYBE"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "notify",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_NOTIFY"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no names",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"action": "SCMP_ACT_ERRNO"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "argument out of range",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 6, "value": 0, "op": "SCMP_CMP_EQ"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown operator",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "value": 0, "op": "SCMP_CMP_SIMILAR"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "errno out of range",
+			data:    `{"defaultAction": "SCMP_ACT_ERRNO", "defaultErrnoRet": 65536}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid kernel version",
+			data:    `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ERRNO", "includes": {"minKernel": "four"}}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProfile([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAction(t *testing.T) {
+	errno := func(n uint) *uint { return &n }
+	tests := []struct {
+		name     string
+		errnoRet *uint
+		want     uint32
+	}{
+		{"SCMP_ACT_ALLOW", nil, 0x7fff0000},
+		{"SCMP_ACT_ERRNO", nil, 0x00050001},
+		{"SCMP_ACT_ERRNO", errno(38), 0x00050026},
+		{"SCMP_ACT_KILL", nil, 0x00000000},
+		{"SCMP_ACT_KILL_PROCESS", nil, 0x80000000},
+		{"SCMP_ACT_TRAP", nil, 0x00030000},
+		{"SCMP_ACT_TRACE", nil, 0x7ff00000},
+		{"SCMP_ACT_LOG", nil, 0x7ffc0000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := action(tt.name, tt.errnoRet)
+			if err != nil {
+				t.Fatalf("action() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("action() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKernelVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    kernelVersion
+		wantErr bool
+	}{
+		{"4.8", kernelVersion{4, 8}, false},
+		{"6.1.0-13-amd64", kernelVersion{6, 1}, false},
+		{"5.15-rc1", kernelVersion{5, 15}, false},
+		{"6", kernelVersion{}, true},
+		{"a.b", kernelVersion{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := parseKernelVersion(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKernelVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseKernelVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
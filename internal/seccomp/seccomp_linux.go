@@ -0,0 +1,68 @@
+//go:build linux
+
+package seccomp
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Compile compiles the profile into a filter for the architecture and kernel
+// of the host and a container with the given capabilities, named like
+// CAP_SYS_ADMIN.
+func Compile(p *Profile, capabilities []string) ([]Instruction, error) {
+	t, err := hostTarget(capabilities)
+	if err != nil {
+		return nil, err
+	}
+	return compile(p, t)
+}
+
+// Resolve returns the rules of the profile that apply to the host and a
+// container with the given capabilities, the way Docker passes profiles on
+// to OCI runtimes, which do not know its includes and excludes.
+func Resolve(p *Profile, capabilities []string) (*Profile, error) {
+	t, err := hostTarget(capabilities)
+	if err != nil {
+		return nil, err
+	}
+	return resolve(p, t), nil
+}
+
+func hostTarget(capabilities []string) (target, error) {
+	a, err := findArch(runtime.GOARCH)
+	if err != nil {
+		return target{}, err
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return target{}, fmt.Errorf("failed to get kernel version: %v", err)
+	}
+	kernel, err := parseKernelVersion(unix.ByteSliceToString(uname.Release[:]))
+	if err != nil {
+		return target{}, err
+	}
+	return target{arch: a, capabilities: capabilities, kernel: kernel}, nil
+}
+
+// Install installs the filter on the current thread, which must stay locked
+// until the command is executed or started from it. The filter is inherited
+// by the executed programs and their children and cannot be removed. Without
+// no_new_privs installing it needs CAP_SYS_ADMIN.
+func Install(filter []Instruction) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("empty seccomp filter")
+	}
+	// Instruction has the layout of struct sock_filter
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: (*unix.SockFilter)(unsafe.Pointer(&filter[0])),
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install seccomp filter: %v", err)
+	}
+	return nil
+}
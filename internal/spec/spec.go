@@ -0,0 +1,218 @@
+// Package spec holds the parts of the OCI runtime spec pce understands, the
+// config.json of a bundle. See
+// https://github.com/opencontainers/runtime-spec/blob/main/config.md.
+package spec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/troppes/portable-container-engine/internal/seccomp"
+)
+
+// Version is the version of the runtime spec written by pce.
+const Version = "1.2.0"
+
+// ConfigFile is the name of the config in a bundle.
+const ConfigFile = "config.json"
+
+// Spec is the configuration of a container in a bundle.
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Process is the command of the container.
+type Process struct {
+	Terminal        bool          `json:"terminal,omitempty"`
+	User            User          `json:"user"`
+	Args            []string      `json:"args"`
+	Env             []string      `json:"env,omitempty"`
+	Cwd             string        `json:"cwd"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
+	Rlimits         []Rlimit      `json:"rlimits,omitempty"`
+	NoNewPrivileges bool          `json:"noNewPrivileges,omitempty"`
+}
+
+// User is the user the process runs as, given as IDs in the container.
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// Capabilities are the capability sets of the process, with names like
+// CAP_NET_ADMIN.
+type Capabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// Rlimit is a resource limit of the process, like RLIMIT_NOFILE.
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root is the root filesystem, a path relative to the bundle or absolute.
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount is mounted into the container on top of the root filesystem.
+// Sources of bind mounts may be relative to the bundle.
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Linux holds the Linux specific configuration.
+type Linux struct {
+	UIDMappings   []IDMapping      `json:"uidMappings,omitempty"`
+	GIDMappings   []IDMapping      `json:"gidMappings,omitempty"`
+	Namespaces    []Namespace      `json:"namespaces,omitempty"`
+	Resources     *Resources       `json:"resources,omitempty"`
+	Seccomp       *seccomp.Profile `json:"seccomp,omitempty"`
+	MaskedPaths   []string         `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string         `json:"readonlyPaths,omitempty"`
+}
+
+// IDMapping maps IDs of a user namespace onto those of the host.
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// Types of namespaces
+const (
+	PIDNamespace     = "pid"
+	NetworkNamespace = "network"
+	MountNamespace   = "mount"
+	IPCNamespace     = "ipc"
+	UTSNamespace     = "uts"
+	UserNamespace    = "user"
+	CgroupNamespace  = "cgroup"
+	TimeNamespace    = "time"
+)
+
+// Namespace is a namespace the container gets, a new one unless Path names
+// an existing one to join.
+type Namespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// Resources are the cgroup limits of the container.
+type Resources struct {
+	Memory *MemoryResources `json:"memory,omitempty"`
+	CPU    *CPUResources    `json:"cpu,omitempty"`
+	Pids   *PidsResources   `json:"pids,omitempty"`
+}
+
+// MemoryResources limit the memory of the container in bytes.
+type MemoryResources struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// CPUResources weight the CPU time of the container against others and
+// limit it to Quota per Period, in microseconds.
+type CPUResources struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+// PidsResources limit the number of processes of the container.
+type PidsResources struct {
+	Limit int64 `json:"limit"`
+}
+
+// HasNamespace reports whether the container gets a namespace of the type.
+func (s *Spec) HasNamespace(typ string) bool {
+	if s.Linux == nil {
+		return false
+	}
+	for _, ns := range s.Linux.Namespaces {
+		if ns.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the config of the bundle in dir.
+func Load(dir string) (*Spec, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %v", err)
+	}
+	var s Spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ConfigFile, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ConfigFile, err)
+	}
+	return &s, nil
+}
+
+// Save writes the spec as the config of the bundle in dir, which must not
+// have one yet.
+func (s *Spec) Save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, ConfigFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists in %s", ConfigFile, dir)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Spec) validate() error {
+	if s.Version == "" {
+		return fmt.Errorf("ociVersion is missing")
+	}
+	if s.Root == nil || s.Root.Path == "" {
+		return fmt.Errorf("root.path is missing")
+	}
+	if s.Process == nil {
+		return fmt.Errorf("process is missing")
+	}
+	if len(s.Process.Args) == 0 {
+		return fmt.Errorf("process.args is missing")
+	}
+	if s.Process.Cwd == "" || !filepath.IsAbs(s.Process.Cwd) {
+		return fmt.Errorf("process.cwd must be an absolute path")
+	}
+	for _, m := range s.Mounts {
+		if !filepath.IsAbs(m.Destination) {
+			return fmt.Errorf("mount destination %q is not absolute", m.Destination)
+		}
+	}
+	return nil
+}
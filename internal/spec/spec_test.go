@@ -0,0 +1,86 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pce-spec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Spec{
+		Version: Version,
+		Process: &Process{
+			User: User{UID: 101, GID: 101, AdditionalGids: []uint32{5}},
+			Args: []string{"nginx", "-g", "daemon off;"},
+			Env:  []string{"PATH=/usr/bin"},
+			Cwd:  "/",
+			Rlimits: []Rlimit{
+				{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 512},
+			},
+		},
+		Root:     &Root{Path: "rootfs"},
+		Hostname: "web",
+		Mounts:   []Mount{{Destination: "/data", Type: "bind", Source: "data", Options: []string{"rbind", "ro"}}},
+		Linux:    &Linux{Namespaces: []Namespace{{Type: PIDNamespace}, {Type: NetworkNamespace}}},
+	}
+	if err := s.Save(dir); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err := s.Save(dir); err == nil {
+		t.Error("Save() over an existing config succeeded")
+	}
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("Load() = %+v, want %+v", loaded, s)
+	}
+	if !loaded.HasNamespace(NetworkNamespace) || loaded.HasNamespace(TimeNamespace) {
+		t.Error("HasNamespace() does not match the namespaces of the spec")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pce-spec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"not json", `ociVersion: 1.2.0`},
+		{"no version", `{"root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}}`},
+		{"no root", `{"ociVersion": "1.2.0", "process": {"args": ["sh"], "cwd": "/"}}`},
+		{"no process", `{"ociVersion": "1.2.0", "root": {"path": "rootfs"}}`},
+		{"no args", `{"ociVersion": "1.2.0", "root": {"path": "rootfs"}, "process": {"cwd": "/"}}`},
+		{"relative cwd", `{"ociVersion": "1.2.0", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "tmp"}}`},
+		{"relative mount", `{"ociVersion": "1.2.0", "root": {"path": "rootfs"}, "process": {"args": ["sh"], "cwd": "/"}, "mounts": [{"destination": "data"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(dir, ConfigFile), []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(dir); err == nil {
+				t.Error("Load() succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Load() of a missing bundle succeeded")
+	}
+}
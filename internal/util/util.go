@@ -1,6 +1,10 @@
 package util
 
-import "strings"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
 
 func Must(err error) {
 	if err != nil {
@@ -13,3 +17,20 @@ func Contains(s, substr string) bool {
 	substr = strings.ToLower(substr)
 	return strings.Contains(s, substr)
 }
+
+// DataRoot returns the directory pce keeps its persistent data in. It can be
+// overridden with PCE_ROOT and otherwise follows the XDG base directory spec,
+// defaulting to ~/.local/share/pce.
+func DataRoot() string {
+	if root := os.Getenv("PCE_ROOT"); root != "" {
+		return root
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "pce")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "pce")
+	}
+	return filepath.Join(home, ".local", "share", "pce")
+}
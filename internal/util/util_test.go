@@ -2,6 +2,7 @@ package util
 
 import (
 	"errors"
+	"path/filepath"
 	"testing"
 )
 
@@ -56,3 +57,21 @@ func TestMust(t *testing.T) {
 		Must(errors.New("test error"))
 	})
 }
+
+func TestDataRoot(t *testing.T) {
+	t.Run("PCE_ROOT takes precedence", func(t *testing.T) {
+		t.Setenv("PCE_ROOT", "/tmp/pce-root")
+		t.Setenv("XDG_DATA_HOME", "/tmp/xdg")
+		if got := DataRoot(); got != "/tmp/pce-root" {
+			t.Errorf("DataRoot() = %v, want %v", got, "/tmp/pce-root")
+		}
+	})
+
+	t.Run("XDG_DATA_HOME is honored", func(t *testing.T) {
+		t.Setenv("PCE_ROOT", "")
+		t.Setenv("XDG_DATA_HOME", "/tmp/xdg")
+		if got := DataRoot(); got != filepath.Join("/tmp/xdg", "pce") {
+			t.Errorf("DataRoot() = %v, want %v", got, filepath.Join("/tmp/xdg", "pce"))
+		}
+	})
+}